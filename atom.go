@@ -10,6 +10,18 @@ import (
 	"time"
 )
 
+// XML namespaces used by the feeds.
+const (
+	atomNS       = "http://www.w3.org/2005/Atom"
+	gphotoNS     = "http://schemas.google.com/photos/2007"
+	mediaNS      = "http://search.yahoo.com/mrss/"
+	exifNS       = "http://schemas.google.com/photos/exif/2007"
+	georssNS     = "http://www.georss.org/georss"
+	gmlNS        = "http://www.opengis.net/gml"
+	gdNS         = "http://schemas.google.com/g/2005"
+	openSearchNS = "http://a9.com/-/spec/opensearch/1.1/"
+)
+
 type Atom struct {
 	ID           string    `xml:"id"`
 	Name         string    `xml:"name"`
@@ -24,39 +36,61 @@ type Atom struct {
 	TotalResults int       `xml:"totalResults"`
 	ItemsPerPage int       `xml:"itemsPerPage"`
 	Entries      []Entry   `xml:"entry"`
+
+	Categories []Category `xml:"category"`
 }
 
 type Entry struct {
-	ETag      string       `xml:"etag,attr"`
-	EntryID   string       `xml:"http://www.w3.org/2005/Atom id"`
-	ID        string       `xml:"http://schemas.google.com/photos/2007 id"`
-	Published time.Time    `xml:"published"`
-	Updated   time.Time    `xml:"updated"`
-	Name      string       `xml:"http://schemas.google.com/photos/2007 name"`
-	Title     string       `xml:"title"`
-	Summary   string       `xml:"summary"`
-	Rights    string       `xml:"rights"`
-	AlbumType string       `xml:"albumType"`
-	Links     []Link       `xml:"link"`
-	Author    Author       `xml:"author"`
+	ETag      string    `xml:"etag,attr"`
+	EntryID   string    `xml:"http://www.w3.org/2005/Atom id"`
+	ID        string    `xml:"http://schemas.google.com/photos/2007 id"`
+	Published time.Time `xml:"published"`
+	Updated   time.Time `xml:"updated"`
+	Name      string    `xml:"http://schemas.google.com/photos/2007 name"`
+	Title     string    `xml:"title"`
+	Summary   string    `xml:"summary"`
+	Rights    string    `xml:"rights"`
+	AlbumType string    `xml:"albumType"`
+	Links     []Link    `xml:"link"`
+	Author    Author    `xml:"author"`
+
+	Categories []Category `xml:"category"`
+
 	Location  string       `xml:"http://schemas.google.com/photos/2007 location"`
 	NumPhotos int          `xml:"numphotos"`
 	Content   EntryContent `xml:"content"`
 	Media     *Media       `xml:"group"`
 	Exif      *Exif        `xml:"tags"`
 	Point     string       `xml:"where>Point>pos"`
+
+	LowerCorner string `xml:"where>Envelope>lowerCorner"`
+	UpperCorner string `xml:"where>Envelope>upperCorner"`
+
+	NumPhotosRemaining int    `xml:"http://schemas.google.com/photos/2007 numphotosremaining"`
+	BytesUsed          int64  `xml:"http://schemas.google.com/photos/2007 bytesUsed"`
+	Access             string `xml:"http://schemas.google.com/photos/2007 access"`
+	Timestamp          int64  `xml:"http://schemas.google.com/photos/2007 timestamp"`
+	CommentingEnabled  bool   `xml:"http://schemas.google.com/photos/2007 commentingEnabled"`
+	AllowDownloads     *bool  `xml:"http://schemas.google.com/photos/2007 allowDownloads"`
+	AllowPrints        *bool  `xml:"http://schemas.google.com/photos/2007 allowPrints"`
+
+	AlbumID      string `xml:"http://schemas.google.com/photos/2007 albumid"`
+	Checksum     string `xml:"http://schemas.google.com/photos/2007 checksum"`
+	Size         int64  `xml:"http://schemas.google.com/photos/2007 size"`
+	Rotation     int    `xml:"http://schemas.google.com/photos/2007 rotation"`
+	CommentCount int    `xml:"http://schemas.google.com/photos/2007 commentCount"`
+	Version      int64  `xml:"http://schemas.google.com/photos/2007 version"`
+
+	User      string `xml:"http://schemas.google.com/photos/2007 user"`
+	Nickname  string `xml:"http://schemas.google.com/photos/2007 nickname"`
+	Thumbnail string `xml:"http://schemas.google.com/photos/2007 thumbnail"`
+	PhotoID   string `xml:"http://schemas.google.com/photos/2007 photoid"`
+	Weight    int    `xml:"http://schemas.google.com/photos/2007 weight"`
 }
 
-type Exif struct {
-	FStop       float32 `xml:"fstop"`
-	Make        string  `xml:"make"`
-	Model       string  `xml:"model"`
-	Exposure    float32 `xml:"exposure"`
-	Flash       bool    `xml:"flash"`
-	FocalLength float32 `xml:"focallength"`
-	ISO         int32   `xml:"iso"`
-	Timestamp   int64   `xml:"time"`
-	UID         string  `xml:"imageUniqueID"`
+type Category struct {
+	Scheme string `xml:"scheme,attr"`
+	Term   string `xml:"term,attr"`
 }
 
 type Link struct {
@@ -66,7 +100,7 @@ type Link struct {
 }
 
 type Media struct {
-	Title       string         `xml:"http://search.yahoo.com/mrss title"`
+	Title       string         `xml:"http://search.yahoo.com/mrss/ title"`
 	Description string         `xml:"description"`
 	Keywords    string         `xml:"keywords"`
 	Content     []MediaContent `xml:"content"`
@@ -84,6 +118,8 @@ type MediaContent struct {
 type EntryContent struct {
 	URL  string `xml:"src,attr"`
 	Type string `xml:"type,attr"`
+	// Text is the inline content, e.g. the text of a comment.
+	Text string `xml:",chardata"`
 }
 
 type Author struct {
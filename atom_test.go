@@ -5,9 +5,14 @@
 package picago
 
 import (
+	"bytes"
+	"compress/gzip"
 	"encoding/xml"
+	"fmt"
+	"io"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -260,8 +265,14 @@ func mustParseAtom(t *testing.T, file string) *Atom {
 		t.Fatal(err)
 	}
 	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(file, ".gz") {
+		if r, err = gzip.NewReader(f); err != nil {
+			t.Fatal(err)
+		}
+	}
 	a := new(Atom)
-	if err := xml.NewDecoder(f).Decode(a); err != nil {
+	if err := xml.NewDecoder(r).Decode(a); err != nil {
 		t.Fatal(err)
 	}
 	return a
@@ -289,6 +300,43 @@ func TestVideoInGallery(t *testing.T) {
 	if !reflect.DeepEqual(p.Keywords, wantKW) {
 		t.Errorf("Keywords = %q; want %q", p.Keywords, wantKW)
 	}
+	if got, want := p.AlbumID, "6040139514831220113"; got != want {
+		t.Errorf("AlbumID = %q; want %q", got, want)
+	}
+	if p.Size != 3289621 || p.Version != 9 || p.CommentCount != 0 || p.Checksum != "" {
+		t.Errorf("Size=%d Version=%d CommentCount=%d Checksum=%q", p.Size, p.Version, p.CommentCount, p.Checksum)
+	}
+	if got, want := p.Timestamp, time.Unix(1406607562, 0).UTC(); !got.Equal(want) {
+		t.Errorf("Timestamp = %s; want %s", got, want)
+	}
+	if len(p.Thumbnails) != 3 {
+		t.Fatalf("got %d thumbnails; want 3", len(p.Thumbnails))
+	}
+	if got, want := p.Thumbnails[2], (Thumbnail{URL: "https://lh3.googleusercontent.com/-STi02l3-QBE/U9a-hOwEssI/AAAAAAAAAC0/jYJqb4T2I4E/s288/VID_20140728_141919.png", Width: 288, Height: 162}); got != want {
+		t.Errorf("Thumbnails[2] = %+v; want %+v", got, want)
+	}
+}
+
+func TestMediaTitle(t *testing.T) {
+	atom := mustParseAtom(t, "testdata/gallery-with-a-video.xml")
+	if got, want := atom.Entries[2].Media.Title, "IMG_2034.JPG"; got != want {
+		t.Errorf("media:title = %q; want %q", got, want)
+	}
+
+	// media:title names the photo if the entry has no title.
+	var e Entry
+	if err := xml.Unmarshal([]byte(`<entry xmlns='http://www.w3.org/2005/Atom' xmlns:media='http://search.yahoo.com/mrss/'>
+  <media:group><media:title type='plain'>a.jpg</media:title></media:group>
+</entry>`), &e); err != nil {
+		t.Fatal(err)
+	}
+	p, err := e.photo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Filename != "a.jpg" {
+		t.Errorf("Filename = %q; want a.jpg", p.Filename)
+	}
 }
 
 func TestAlbumFromEntry(t *testing.T) {
@@ -308,9 +356,9 @@ func TestAlbumFromEntry(t *testing.T) {
 		return ret
 	}
 	want := []Album{
-		Album{ID: "6040139514831220113", Name: "BikingWithBlake", Title: "Biking with Blake", Rights: "protected", Description: "Description is biking up San Bruno mountain.\n\nAnd a newline.", Location: "San Bruno Mt, CA", AuthorName: "Gast Erson", AuthorURI: "https://picasaweb.google.com/114403741484702971746", Published: tm("2014-07-22T07:00:00.000Z"), Updated: tm("2014-07-28T22:22:25.577Z"), URL: "https://picasaweb.google.com/114403741484702971746/BikingWithBlake"},
-		Album{ID: "6041693388376552305", Name: "Mexico", Title: "Mexico", Rights: "protected", Description: "", Location: "", AuthorName: "Gast Erson", AuthorURI: "https://picasaweb.google.com/114403741484702971746", Published: tm("2014-07-30T03:36:00.000Z"), Updated: tm("2014-07-30T19:46:05.346Z"), URL: "https://picasaweb.google.com/114403741484702971746/Mexico"},
-		Album{ID: "6041709940397032273", Name: "TestingOver2048", Title: "testing over 2048", Rights: "protected", Description: "", Location: "", AuthorName: "Gast Erson", AuthorURI: "https://picasaweb.google.com/114403741484702971746", Published: tm("2014-07-30T04:40:14.000Z"), Updated: tm("2014-07-30T05:01:02.919Z"), URL: "https://picasaweb.google.com/114403741484702971746/TestingOver2048"},
+		Album{ID: "6040139514831220113", Name: "BikingWithBlake", Title: "Biking with Blake", Rights: "protected", Description: "Description is biking up San Bruno mountain.\n\nAnd a newline.", Location: "San Bruno Mt, CA", AuthorName: "Gast Erson", AuthorURI: "https://picasaweb.google.com/114403741484702971746", Published: tm("2014-07-22T07:00:00.000Z"), Updated: tm("2014-07-28T22:22:25.577Z"), URL: "https://picasaweb.google.com/114403741484702971746/BikingWithBlake", NumPhotos: 3, NumPhotosRemaining: 1997, BytesUsed: 4037418, Access: "protected", Timestamp: tm("2014-07-22T07:00:00.000Z"), AllowDownloads: true, AllowPrints: true, Cover: Thumbnail{URL: "https://lh4.googleusercontent.com/-VSf28XLm47g/U9Li4v9QrZE/AAAAAAAAAD0/Zkcd4B_xKl8/s160-c/BikingWithBlake.jpg", Width: 160, Height: 160}},
+		Album{ID: "6041693388376552305", Name: "Mexico", Title: "Mexico", Rights: "protected", Description: "", Location: "", AuthorName: "Gast Erson", AuthorURI: "https://picasaweb.google.com/114403741484702971746", Published: tm("2014-07-30T03:36:00.000Z"), Updated: tm("2014-07-30T19:46:05.346Z"), URL: "https://picasaweb.google.com/114403741484702971746/Mexico", NumPhotos: 2, NumPhotosRemaining: 1998, BytesUsed: 1153277, Access: "protected", Timestamp: tm("2014-07-30T03:36:00.000Z"), AllowDownloads: true, AllowPrints: true, Cover: Thumbnail{URL: "https://lh6.googleusercontent.com/-O5-IFajXg5w/U9hoIGACs3E/AAAAAAAAAHQ/f0YBhBXH3Q4/s160-c/Mexico.jpg", Width: 160, Height: 160}},
+		Album{ID: "6041709940397032273", Name: "TestingOver2048", Title: "testing over 2048", Rights: "protected", Description: "", Location: "", AuthorName: "Gast Erson", AuthorURI: "https://picasaweb.google.com/114403741484702971746", Published: tm("2014-07-30T04:40:14.000Z"), Updated: tm("2014-07-30T05:01:02.919Z"), URL: "https://picasaweb.google.com/114403741484702971746/TestingOver2048", NumPhotos: 1, NumPhotosRemaining: 1999, BytesUsed: 3591165, Access: "protected", Timestamp: tm("2014-07-30T04:40:14.000Z"), AllowDownloads: true, AllowPrints: true, Cover: Thumbnail{URL: "https://lh5.googleusercontent.com/-wBiTtXKn23s/U9h3LjFPw1E/AAAAAAAAAGE/XL--iYJ62NQ/s160-c/TestingOver2048.jpg", Width: 160, Height: 160}},
 	}
 	if len(got) != len(want) {
 		t.Fatalf("got %d entries, want %d", len(got), len(want))
@@ -324,3 +372,337 @@ func TestAlbumFromEntry(t *testing.T) {
 		}
 	}
 }
+
+func TestMediaSelectors(t *testing.T) {
+	atom := mustParseAtom(t, "testdata/gallery-with-a-video.xml")
+	p, err := atom.Entries[3].photo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Media) != 6 {
+		t.Fatalf("got %d media variants; want 6", len(p.Media))
+	}
+	if !p.IsVideo() {
+		t.Errorf("IsVideo = false; want true")
+	}
+	for _, tc := range []struct {
+		name string
+		sel  MediaSelector
+		want string
+	}{
+		{"default", nil, "video/mpeg4 1920x1080"},
+		{"largest", Largest, "video/mpeg4 1920x1080"},
+		{"original", OriginalImage, "image/gif 854x480"},
+		{"max720", MaxDimension(720), "video/mpeg4 640x360"},
+		{"flash", PreferType("application/x-shockwave-flash", nil), "application/x-shockwave-flash 854x480"},
+		{"image/", PreferType("image/", nil), "image/gif 854x480"},
+		{"fallback", PreferType("video/webm", OriginalImage), "image/gif 854x480"},
+	} {
+		mc, ok := p.Select(tc.sel)
+		if !ok {
+			t.Errorf("%s: nothing selected", tc.name)
+			continue
+		}
+		if got := fmt.Sprintf("%s %dx%d", mc.Type, mc.Width, mc.Height); got != tc.want {
+			t.Errorf("%s: got %s; want %s", tc.name, got, tc.want)
+		}
+	}
+	if _, ok := p.Select(PreferType("video/webm", nil)); ok {
+		t.Errorf("video/webm selected; want none")
+	}
+}
+
+func TestExif(t *testing.T) {
+	atom := mustParseAtom(t, "testdata/list_photos.xml.gz")
+	p, err := atom.Entries[0].photo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := p.Exif
+	if e.Make != "google" || e.Model != "Nexus S" || e.UID != "4f864a436090beaf0000000000000000" {
+		t.Errorf("got %+v", e)
+	}
+	if e.ISO == nil || *e.ISO != 100 || e.Flash == nil || !*e.Flash || e.FStop == nil || *e.FStop != 2.6 {
+		t.Errorf("ISO/Flash/FStop mismatch: %+v", e)
+	}
+	if e.Orientation != nil || e.Distance != nil || e.Latitude != nil {
+		t.Errorf("missing tags should be nil: %+v", e)
+	}
+	if got, want := e.ExposureString(), "1/15"; got != want {
+		t.Errorf("ExposureString = %q; want %q", got, want)
+	}
+	if got, ok := e.Time(); !ok || !got.Equal(time.Date(2014, 3, 3, 7, 3, 18, 0, time.UTC)) {
+		t.Errorf("Time = %s, %t", got, ok)
+	}
+	if got, ok := e.FocalLength35mm(7.6); !ok || got != 26.1 {
+		t.Errorf("FocalLength35mm = %v, %t; want 26.1", got, ok)
+	}
+
+	var zero Exif
+	if !zero.IsZero() || zero.ExposureString() != "" {
+		t.Errorf("zero Exif: IsZero=%t ExposureString=%q", zero.IsZero(), zero.ExposureString())
+	}
+	if _, ok := zero.Time(); ok {
+		t.Errorf("zero Exif has Time")
+	}
+	var epoch int64
+	if _, ok := (Exif{Timestamp: &epoch}).Time(); ok {
+		t.Errorf("Exif with 0 timestamp has Time")
+	}
+	published := time.Date(2014, 7, 21, 7, 0, 0, 0, time.UTC)
+	if got, ok := (Photo{Published: published, Exif: Exif{Timestamp: &epoch}}).Time(DefaultTimeOrder); !ok || !got.Equal(published) {
+		t.Errorf("got %s (%t), wanted the published time", got, ok)
+	}
+	two := 2.0
+	if got := (Exif{Exposure: &two}).ExposureString(); got != "2" {
+		t.Errorf("ExposureString = %q; want 2", got)
+	}
+}
+
+func TestGeo(t *testing.T) {
+	var feed Atom
+	if err := xml.Unmarshal([]byte(photosXML), &feed); err != nil {
+		t.Fatal(err)
+	}
+	p, err := feed.Entries[0].photo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Point == nil || *p.Point != (GeoPoint{37.427399548633325, -122.1703290939331}) {
+		t.Errorf("Point = %v", p.Point)
+	}
+	if p.Latitude != p.Point.Latitude || p.Longitude != p.Point.Longitude {
+		t.Errorf("Latitude/Longitude = %v/%v; want %v", p.Latitude, p.Longitude, p.Point)
+	}
+	wantBox := GeoBox{
+		Lower: GeoPoint{37.42054944692195, -122.1825385093689},
+		Upper: GeoPoint{37.4342496503447, -122.15811967849731},
+	}
+	if p.Box == nil || *p.Box != wantBox {
+		t.Errorf("Box = %v; want %v", p.Box, wantBox)
+	}
+
+	for _, tc := range []struct {
+		in   string
+		want *GeoPoint
+		err  bool
+	}{
+		{"", nil, false},
+		{"0.0 0.0", nil, false},
+		{" 0  0 ", nil, false},
+		{"\n\t47.5\t 19.04\n", &GeoPoint{47.5, 19.04}, false},
+		{"91 0", nil, true},
+		{"0 -181", nil, true},
+		{"47.5", nil, true},
+		{"47.5 x", nil, true},
+	} {
+		got, err := ParseGeoPoint(tc.in)
+		if (err != nil) != tc.err {
+			t.Errorf("%q: got error %v", tc.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: got %v; want %v", tc.in, got, tc.want)
+		}
+	}
+
+	for _, tc := range []struct {
+		lower, upper string
+		err          bool
+	}{
+		{"47 19", "48 20", false},
+		{"-10 170", "10 -170", false}, // across the antimeridian
+		{"48 19", "47 20", true},
+		{"47 20", "48 19", true},
+		{"47 19", "48 181", true},
+	} {
+		if _, err := ParseGeoBox(tc.lower, tc.upper); (err != nil) != tc.err {
+			t.Errorf("%q - %q: got error %v", tc.lower, tc.upper, err)
+		}
+	}
+
+	// A broken location is dropped, not the photo.
+	e := feed.Entries[0]
+	e.Point = "91 0"
+	if p, err = e.photo(); err != nil || p.Point != nil || p.Box != nil {
+		t.Errorf("got %v, %v, %v; wanted no location, no error", p.Point, p.Box, err)
+	}
+}
+
+func TestFeedDecoder(t *testing.T) {
+	for _, fn := range []string{
+		"testdata/album-list.xml",
+		"testdata/gallery-with-a-video.xml",
+		"testdata/list_albums.xml.gz",
+		"testdata/list_photos.xml.gz",
+		"testdata/user.xml",
+	} {
+		want := mustParseAtom(t, fn)
+
+		f, err := os.Open(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r io.Reader = f
+		if strings.HasSuffix(fn, ".gz") {
+			if r, err = gzip.NewReader(f); err != nil {
+				t.Fatal(err)
+			}
+		}
+		fd := NewFeedDecoder(r)
+		var entries []Entry
+		for {
+			e, err := fd.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", fn, err)
+			}
+			if len(entries) == 0 && fd.Feed().ID != want.ID {
+				t.Errorf("%s: feed ID = %q at the first entry; want %q", fn, fd.Feed().ID, want.ID)
+			}
+			entries = append(entries, *e)
+		}
+		f.Close()
+		got := *fd.Feed()
+		got.Entries = entries
+		if !reflect.DeepEqual(&got, want) {
+			t.Errorf("%s: streamed feed differs from ParseAtom", fn)
+		}
+	}
+
+	fd := NewFeedDecoder(strings.NewReader(`<entry xmlns='http://www.w3.org/2005/Atom'><title>a.jpg</title></entry>`))
+	e, err := fd.Next()
+	if err != nil || e.Title != "a.jpg" {
+		t.Fatalf("single entry: %+v, %v", e, err)
+	}
+	if _, err = fd.Next(); err != io.EOF {
+		t.Errorf("after the single entry got %v; want EOF", err)
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	for _, fn := range []string{
+		"testdata/album-list.xml",
+		"testdata/gallery-with-a-video.xml",
+		"testdata/list_albums.xml.gz",
+		"testdata/list_photos.xml.gz",
+	} {
+		want := mustParseAtom(t, fn)
+		b, err := xml.Marshal(want)
+		if err != nil {
+			t.Fatalf("%s: %v", fn, err)
+		}
+		got, err := ParseAtom(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("%s: %v\n%s", fn, err, b)
+		}
+		if len(got.Entries) != len(want.Entries) {
+			t.Fatalf("%s: got %d entries; want %d", fn, len(got.Entries), len(want.Entries))
+		}
+		for i := range want.Entries {
+			if !reflect.DeepEqual(got.Entries[i], want.Entries[i]) {
+				t.Errorf("%s[%d]: entry differs:\n got %+v\nwant %+v", fn, i, got.Entries[i], want.Entries[i])
+			}
+		}
+		for i := range want.Entries {
+			we := &want.Entries[i]
+			a := we.album()
+			var e Entry
+			if err := e.DecodeReader(strings.NewReader(mustMarshal(t, a.Entry()))); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(e.album(), a) {
+				t.Errorf("%s[%d]: album differs:\n got %+v\nwant %+v", fn, i, e.album(), a)
+			}
+
+			p, err := we.photo()
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.Position = 0
+			e = Entry{}
+			if err := e.DecodeReader(strings.NewReader(mustMarshal(t, p.Entry()))); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := e.photo(); err != nil || !reflect.DeepEqual(got, p) {
+				t.Errorf("%s[%d]: photo differs (%v):\n got %+v\nwant %+v", fn, i, err, got, p)
+			}
+		}
+	}
+}
+
+func mustMarshal(t *testing.T, v interface{}) string {
+	b, err := xml.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestDecodeFeed(t *testing.T) {
+	for _, tc := range []struct {
+		file                  string
+		kind                  Kind
+		albums, photos, users int
+	}{
+		{"testdata/album-list.xml", KindUser, 3, 0, 0},
+		{"testdata/gallery-with-a-video.xml", KindAlbum, 0, 4, 0},
+		{"testdata/user.xml", KindUser, 0, 0, 2},
+	} {
+		f, err := os.Open(tc.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		feed, err := DecodeFeed(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", tc.file, err)
+		}
+		if feed.Kind() != tc.kind || len(feed.Albums) != tc.albums || len(feed.Photos) != tc.photos || len(feed.Users) != tc.users {
+			t.Errorf("%s: got kind %q with %d albums, %d photos, %d users; want %q %d %d %d", tc.file,
+				feed.Kind(), len(feed.Albums), len(feed.Photos), len(feed.Users),
+				tc.kind, tc.albums, tc.photos, tc.users)
+		}
+	}
+
+	f, err := os.Open("testdata/user.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed, err := DecodeFeed(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(feed.Users) != 2 || feed.Users[0].ID != "106948621299403" || feed.Users[0].Name != "Petra " {
+		t.Errorf("users from JSON: %+v", feed.Users)
+	}
+
+	const comments = `<feed xmlns='http://www.w3.org/2005/Atom' xmlns:gphoto='http://schemas.google.com/photos/2007'>
+  <category scheme='http://schemas.google.com/g/2005#kind' term='http://schemas.google.com/photos/2007#photo'/>
+  <entry>
+    <category scheme='http://schemas.google.com/g/2005#kind' term='http://schemas.google.com/photos/2007#comment'/>
+    <content type='text'>Nice bike!</content>
+    <author><name>Liz</name></author>
+    <gphoto:id>commentID</gphoto:id>
+    <gphoto:photoid>photoID</gphoto:photoid>
+  </entry>
+  <entry>
+    <category scheme='http://schemas.google.com/g/2005#kind' term='http://schemas.google.com/photos/2007#tag'/>
+    <title type='text'>bike</title>
+    <gphoto:weight>3</gphoto:weight>
+  </entry>
+</feed>`
+	if feed, err = DecodeFeed(strings.NewReader(comments)); err != nil {
+		t.Fatal(err)
+	}
+	wantC := []Comment{{ID: "commentID", PhotoID: "photoID", Text: "Nice bike!", AuthorName: "Liz"}}
+	if !reflect.DeepEqual(feed.Comments, wantC) {
+		t.Errorf("got comments %+v; want %+v", feed.Comments, wantC)
+	}
+	if wantT := []Tag{{Name: "bike", Weight: 3}}; !reflect.DeepEqual(feed.Tags, wantT) {
+		t.Errorf("got tags %+v; want %+v", feed.Tags, wantT)
+	}
+}
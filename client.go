@@ -0,0 +1,29 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package picago
+
+import "net/http"
+
+// A Client is an authorized *http.Client with per-client settings.
+//
+// The package-level functions (GetAlbums, GetPhotos...) use a Client with
+// the default settings.
+type Client struct {
+	*http.Client
+
+	// Format is the format the feeds are requested in.
+	Format Format
+}
+
+// Format is the wire format of the feeds.
+type Format int
+
+const (
+	// FormatAtom is the Atom XML format, the default.
+	FormatAtom = Format(iota)
+	// FormatJSON is the GData JSON format (alt=json),
+	// which is smaller and faster to decode.
+	FormatJSON
+)
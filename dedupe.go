@@ -0,0 +1,365 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package picago
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A DedupeMode says how a Deduper puts the stored copy of a photo
+// to its place.
+type DedupeMode string
+
+const (
+	// DedupeHardlink makes the photo a hard link to the stored copy.
+	DedupeHardlink = DedupeMode("hardlink")
+
+	// DedupeSymlink makes the photo a relative symbolic link to the
+	// stored copy.
+	DedupeSymlink = DedupeMode("symlink")
+
+	// DedupeBlobs leaves the photo only in the store, and records it in
+	// the BlobIndexName file of its directory.
+	DedupeBlobs = DedupeMode("blobs")
+)
+
+// ParseDedupeMode parses "hardlink", "symlink" or "blobs".
+func ParseDedupeMode(s string) (DedupeMode, error) {
+	switch m := DedupeMode(strings.ToLower(s)); m {
+	case DedupeHardlink, DedupeSymlink, DedupeBlobs:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown dedupe mode %q (known: hardlink, symlink, blobs)", s)
+}
+
+// BlobIndexName is the name of the per-directory index of DedupeBlobs.
+const BlobIndexName = "index.json"
+
+// A BlobIndexEntry is the record of a photo in a BlobIndexName file,
+// which maps the photos' file names to BlobIndexEntries.
+type BlobIndexEntry struct {
+	PhotoID string
+
+	// Blob is the path of the content, relative to the store's Dir.
+	Blob string
+	Size int64
+}
+
+// keysLogName is the file in the store which records the blobs of the
+// Exif UIDs and checksums, one "key\tblob" per line.
+const keysLogName = "keys.log"
+
+// A Deduper stores each distinct photo only once, in a content-addressed
+// store (Dir/xx/sha256.ext), and puts links to it to the photos' places.
+//
+// Photos are recognized by their Exif UID or checksum before downloading
+// (see Reuse), and by the SHA-256 of their content after (see Add).
+// Blobs are never deleted.
+type Deduper struct {
+	Mode DedupeMode
+	Dir  string
+
+	mu   sync.Mutex
+	keys map[string]string
+	log  *os.File
+}
+
+// NewDeduper opens (or creates) the store in dir.
+func NewDeduper(dir string, mode DedupeMode) (*Deduper, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	d := &Deduper{Mode: mode, Dir: dir, keys: make(map[string]string)}
+	fh, err := os.OpenFile(filepath.Join(dir, keysLogName), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, err
+	}
+	if err = d.readKeys(fh); err != nil {
+		fh.Close()
+		return nil, err
+	}
+	d.log = fh
+	return d, nil
+}
+
+// OpenDeduper opens the store in dir read-only, for looking up photos
+// (e.g. for a Plan): nothing is created, a missing store is empty, and
+// Add does not record the keys.
+func OpenDeduper(dir string, mode DedupeMode) (*Deduper, error) {
+	d := &Deduper{Mode: mode, Dir: dir, keys: make(map[string]string)}
+	fh, err := os.Open(filepath.Join(dir, keysLogName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return d, nil
+		}
+		return nil, err
+	}
+	defer fh.Close()
+	return d, d.readKeys(fh)
+}
+
+// readKeys reads the "key\tblob" lines of the keys log.
+func (d *Deduper) readKeys(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if i := strings.IndexByte(scanner.Text(), '\t'); i > 0 {
+			d.keys[scanner.Text()[:i]] = scanner.Text()[i+1:]
+		}
+	}
+	return scanner.Err()
+}
+
+// Close closes the store.
+func (d *Deduper) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.log == nil {
+		return nil
+	}
+	err := d.log.Close()
+	d.log = nil
+	return err
+}
+
+// photoKeys returns the keys the photo is recognizable by before download.
+// The Exif UID survives edits, and some cameras write the same one into
+// every photo, so it is only a key together with the size.
+func photoKeys(p Photo) []string {
+	var keys []string
+	if p.Checksum != "" {
+		keys = append(keys, "checksum:"+p.Checksum)
+	}
+	if p.Exif.UID != "" && p.Size > 0 {
+		keys = append(keys, "uid:"+p.Exif.UID+":"+strconv.FormatInt(p.Size, 10))
+	}
+	return keys
+}
+
+// Reuse puts the stored copy of the photo to path, if the photo is
+// already known by its checksum, or Exif UID and size. It reports whether
+// it did.
+func (d *Deduper) Reuse(path string, p Photo) (bool, error) {
+	blob, fi, err := d.lookup(p)
+	if blob == "" || err != nil {
+		return false, err
+	}
+	return true, d.place(path, blob, p.ID, fi.Size())
+}
+
+// lookup returns the existing blob of the photo, if known.
+func (d *Deduper) lookup(p Photo) (string, os.FileInfo, error) {
+	d.mu.Lock()
+	var blob string
+	for _, k := range photoKeys(p) {
+		if blob = d.keys[k]; blob != "" {
+			break
+		}
+	}
+	d.mu.Unlock()
+	if blob == "" {
+		return "", nil, nil
+	}
+	fi, err := os.Stat(filepath.Join(d.Dir, filepath.FromSlash(blob)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return "", nil, err
+	}
+	if p.Size > 0 && !p.IsVideo() && fi.Size() != p.Size {
+		// Not the same photo after all.
+		return "", nil, nil
+	}
+	return blob, fi, nil
+}
+
+// Add moves the downloaded photo at path into the store (unless its
+// content is already there), and puts a link (or index entry) in its place.
+func (d *Deduper) Add(path string, p Photo) error {
+	fh, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	hsh := sha256.New()
+	size, err := io.Copy(hsh, fh)
+	fh.Close()
+	if err != nil {
+		return err
+	}
+	sum := hex.EncodeToString(hsh.Sum(nil))
+	blob := sum[:2] + "/" + sum + strings.ToLower(filepath.Ext(path))
+	blobPath := filepath.Join(d.Dir, filepath.FromSlash(blob))
+	if _, err = os.Stat(blobPath); os.IsNotExist(err) {
+		if err = os.MkdirAll(filepath.Dir(blobPath), 0750); err != nil {
+			return err
+		}
+		if err = moveFile(path, blobPath); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	if err = d.place(path, blob, p.ID, size); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, k := range photoKeys(p) {
+		if d.keys[k] == blob {
+			continue
+		}
+		d.keys[k] = blob
+		if d.log != nil {
+			if _, err = fmt.Fprintf(d.log, "%s\t%s\n", k, blob); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// place puts the blob to path, according to the Mode.
+func (d *Deduper) place(path, blob, photoID string, size int64) error {
+	blobPath := filepath.Join(d.Dir, filepath.FromSlash(blob))
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	switch d.Mode {
+	case DedupeHardlink:
+		return os.Link(blobPath, path)
+	case DedupeSymlink:
+		absBlob, err := filepath.Abs(blobPath)
+		if err != nil {
+			return err
+		}
+		absDir, err := filepath.Abs(filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		target, err := filepath.Rel(absDir, absBlob)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, path)
+	case DedupeBlobs:
+		return d.updateIndex(filepath.Dir(path), func(idx map[string]BlobIndexEntry) {
+			idx[filepath.Base(path)] = BlobIndexEntry{PhotoID: photoID, Blob: blob, Size: size}
+		})
+	}
+	return fmt.Errorf("unknown dedupe mode %q", d.Mode)
+}
+
+// Exists reports whether there is a photo at path: a file, or with
+// DedupeBlobs, an index entry whose blob exists.
+func (d *Deduper) Exists(path string) bool {
+	if d.Mode != DedupeBlobs {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+	idx, err := ReadBlobIndex(filepath.Dir(path))
+	if err != nil {
+		return false
+	}
+	e, ok := idx[filepath.Base(path)]
+	if !ok {
+		return false
+	}
+	_, err = os.Stat(filepath.Join(d.Dir, filepath.FromSlash(e.Blob)))
+	return err == nil
+}
+
+// Rename moves the index entry of the photo from one path to the other,
+// with DedupeBlobs. Files (and links) are to be renamed by the caller.
+func (d *Deduper) Rename(from, to string) error {
+	if d.Mode != DedupeBlobs {
+		return nil
+	}
+	idx, err := ReadBlobIndex(filepath.Dir(from))
+	if err != nil {
+		return err
+	}
+	e, ok := idx[filepath.Base(from)]
+	if !ok {
+		return nil
+	}
+	if err = d.Remove(from); err != nil {
+		return err
+	}
+	return d.updateIndex(filepath.Dir(to), func(idx map[string]BlobIndexEntry) {
+		idx[filepath.Base(to)] = e
+	})
+}
+
+// Remove deletes the index entry of the photo at path, with DedupeBlobs.
+func (d *Deduper) Remove(path string) error {
+	if d.Mode != DedupeBlobs {
+		return nil
+	}
+	return d.updateIndex(filepath.Dir(path), func(idx map[string]BlobIndexEntry) {
+		delete(idx, filepath.Base(path))
+	})
+}
+
+// ReadBlobIndex reads the BlobIndexName file of dir.
+// A missing file results in an empty index.
+func ReadBlobIndex(dir string) (map[string]BlobIndexEntry, error) {
+	idx := make(map[string]BlobIndexEntry)
+	err := readJSON(filepath.Join(dir, BlobIndexName), &idx)
+	if err != nil && os.IsNotExist(err) {
+		err = nil
+	}
+	return idx, err
+}
+
+func (d *Deduper) updateIndex(dir string, f func(map[string]BlobIndexEntry)) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	idx, err := ReadBlobIndex(dir)
+	if err != nil {
+		return err
+	}
+	f(idx)
+	return writeJSON(filepath.Join(dir, BlobIndexName), idx)
+}
+
+// moveFile renames from to to, copying if they are on different devices.
+func moveFile(from, to string) error {
+	if err := os.Rename(from, to); err == nil {
+		return nil
+	}
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmp := to + ".tmp"
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err = dst.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err = os.Rename(tmp, to); err != nil {
+		return err
+	}
+	return os.Remove(from)
+}
@@ -0,0 +1,162 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package picago
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDeduper(t *testing.T) {
+	for _, mode := range []DedupeMode{DedupeHardlink, DedupeSymlink, DedupeBlobs} {
+		mode := mode
+		t.Run(string(mode), func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "picago-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			for _, sub := range []string{"InstantUpload", "Event"} {
+				if err = os.Mkdir(filepath.Join(dir, sub), 0750); err != nil {
+					t.Fatal(err)
+				}
+			}
+			d, err := NewDeduper(filepath.Join(dir, "blobs"), mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Same content, no keys: recognized by the hash.
+			p1 := Photo{ID: "1", Size: 7, Exif: Exif{UID: "uid1"}}
+			first := filepath.Join(dir, "InstantUpload", "a.jpg")
+			second := filepath.Join(dir, "Event", "b.jpg")
+			for _, fn := range []string{first, second} {
+				if err = ioutil.WriteFile(fn, []byte("content"), 0640); err != nil {
+					t.Fatal(err)
+				}
+				if err = d.Add(fn, p1); err != nil {
+					t.Fatal(err)
+				}
+			}
+			blobs, _ := filepath.Glob(filepath.Join(dir, "blobs", "*", "*.jpg"))
+			if len(blobs) != 1 {
+				t.Fatalf("got blobs %q, wanted one", blobs)
+			}
+			for _, fn := range []string{first, second} {
+				if !d.Exists(fn) {
+					t.Errorf("%s does not exist", fn)
+				}
+				if mode == DedupeBlobs {
+					continue
+				}
+				if b, err := ioutil.ReadFile(fn); err != nil || string(b) != "content" {
+					t.Errorf("%s: got %q (%v)", fn, b, err)
+				}
+			}
+			if mode == DedupeBlobs {
+				idx, err := ReadBlobIndex(filepath.Join(dir, "Event"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if e := idx["b.jpg"]; e.PhotoID != "1" || e.Size != 7 || filepath.Join(dir, "blobs", filepath.FromSlash(e.Blob)) != blobs[0] {
+					t.Errorf("got index %+v", idx)
+				}
+			}
+			d.Close()
+
+			// Known by Exif UID and size, even after reopening.
+			if d, err = NewDeduper(filepath.Join(dir, "blobs"), mode); err != nil {
+				t.Fatal(err)
+			}
+			defer d.Close()
+			third := filepath.Join(dir, "Event", "c.jpg")
+			ok, err := d.Reuse(third, Photo{ID: "3", Size: 7, Exif: Exif{UID: "uid1"}})
+			if err != nil || !ok {
+				t.Fatalf("reuse: got %t, %v", ok, err)
+			}
+			if ok, _ = d.Reuse(third, Photo{ID: "4", Size: 7, Exif: Exif{UID: "other"}}); ok {
+				t.Errorf("reused an unknown photo")
+			}
+			// An edited photo (or a camera writing the same UID) differs in size.
+			if ok, _ = d.Reuse(third, Photo{ID: "5", Size: 8, Exif: Exif{UID: "uid1"}}); ok {
+				t.Errorf("reused a photo of another size")
+			}
+			if !d.Exists(third) {
+				t.Errorf("%s does not exist", third)
+			}
+
+			renamed := filepath.Join(dir, "Event", "d.jpg")
+			if mode != DedupeBlobs {
+				if err = os.Rename(third, renamed); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err = d.Rename(third, renamed); err != nil {
+				t.Fatal(err)
+			}
+			if d.Exists(third) || !d.Exists(renamed) {
+				t.Errorf("rename: %t %t", d.Exists(third), d.Exists(renamed))
+			}
+			if mode != DedupeBlobs {
+				if err = os.Remove(renamed); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err = d.Remove(renamed); err != nil {
+				t.Fatal(err)
+			}
+			if d.Exists(renamed) || !d.Exists(second) {
+				t.Errorf("remove: %t %t", d.Exists(renamed), d.Exists(second))
+			}
+		})
+	}
+}
+
+func TestMirrorDedupeChanged(t *testing.T) {
+	fake := &fakePicasa{photos: make(map[string][]Photo), content: make(map[string]string)}
+	fake.albums = []Album{{ID: "1", Name: "Summer", Title: "Summer", ETag: "a1"}}
+	p := fake.photo("1", "11", "a.jpg", "p1", "aaa")
+	p.Size, p.Exif.UID = 3, "uid1"
+	fake.photos["1"] = []Photo{p}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "picago-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d, err := NewDeduper(filepath.Join(dir, ".blobs"), DedupeHardlink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	m := Mirror{
+		Client: &Client{Client: &http.Client{Transport: rewriteTransport{host: srv.Listener.Addr().String()}}},
+		Dir:    dir,
+		Dedupe: d,
+	}
+	if _, err = m.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Edited: the same Exif UID and size, but new content.
+	fake.photo("1", "11", "a.jpg", "p2", "bbb")
+	p.ETag = "p2"
+	fake.photos["1"] = []Photo{p}
+	fake.albums[0].ETag = "a2"
+	if _, err = m.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	fn := filepath.Join(dir, AlbumDirName(fake.albums[0]), "a.jpg")
+	if b, err := ioutil.ReadFile(fn); err != nil || string(b) != "bbb" {
+		t.Errorf("got %q, %v; wanted the new version", b, err)
+	}
+}
@@ -0,0 +1,337 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package picago
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// A Download is the response to a (ranged) download of a photo.
+type Download struct {
+	// Body is the content, starting at Offset.
+	Body io.ReadCloser
+
+	ContentType  string
+	LastModified time.Time
+	ETag         string
+
+	// Offset is the position of Body's first byte in the file.
+	// It is zero if the server ignored the requested range.
+	Offset int64
+
+	// Length is the length of Body, -1 if unknown.
+	Length int64
+
+	// Size is the size of the whole file, -1 if unknown.
+	Size int64
+
+	// pastEnd is true if the server found the offset at or past the end.
+	pastEnd bool
+}
+
+// validatorExt is the extension of the file next to a partial download
+// which holds the validator (ETag or Last-Modified) of its content, to
+// resume it with If-Range.
+const validatorExt = ".validator"
+
+// A SizeMismatchError is returned when the size of a downloaded file
+// differs from the expected.
+type SizeMismatchError struct {
+	Path      string
+	Got, Want int64
+}
+
+func (e *SizeMismatchError) Error() string {
+	return fmt.Sprintf("%s: got %d bytes, wanted %d", e.Path, e.Got, e.Want)
+}
+
+// A StatusError is returned when the server answers with an error status.
+type StatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+	Body       []byte
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", e.URL, e.Status, e.Body)
+}
+
+// newStatusError returns the StatusError of the response, and closes its body.
+func newStatusError(url string, resp *http.Response) *StatusError {
+	buf, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	return &StatusError{URL: url, StatusCode: resp.StatusCode, Status: resp.Status, Body: buf}
+}
+
+// bodyError is an error reading the body of a response.
+type bodyError struct {
+	url, path string
+	err       error
+}
+
+func (e *bodyError) Error() string {
+	return fmt.Sprintf("downloading %s into %s: %v", e.url, e.path, e.err)
+}
+
+// temporary reports whether the failed request may succeed if retried:
+// it timed out, its connection was refused or reset (also while reading
+// the body), the server failed (5xx), or it asks for slowing down
+// (429 Too Many Requests). Canceled requests, TLS and other errors of
+// the client are permanent.
+func temporary(err error) bool {
+	if ue, ok := err.(*url.Error); ok {
+		err = ue.Err
+	}
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return false
+	}
+	switch e := err.(type) {
+	case *StatusError:
+		return e.StatusCode >= http.StatusInternalServerError || e.StatusCode == http.StatusTooManyRequests
+	case *bodyError:
+		return true
+	case net.Error:
+		if e.Timeout() {
+			return true
+		}
+	}
+	if oe, ok := err.(*net.OpError); ok {
+		err = oe.Err
+	}
+	if se, ok := err.(*os.SyscallError); ok {
+		err = se.Err
+	}
+	return err == syscall.ECONNREFUSED || err == syscall.ECONNRESET
+}
+
+// DownloadRange downloads url, starting at offset.
+func DownloadRange(client *http.Client, url string, offset int64) (*Download, error) {
+	return (&Client{Client: client}).DownloadRange(url, offset)
+}
+
+// DownloadRange downloads url, starting at offset.
+//
+// If the server doesn't support ranges, the returned Download's Offset
+// is zero. If offset is at (or past) the end of the file, the returned
+// Body is empty.
+func (c *Client) DownloadRange(url string, offset int64) (*Download, error) {
+	return c.downloadRange(context.Background(), url, offset, "")
+}
+
+// downloadRange downloads url, starting at offset. If ifRange is not
+// empty, it is sent as If-Range, so the server sends the whole file if
+// it has changed.
+func (c *Client) downloadRange(ctx context.Context, url string, offset int64, ifRange string) (*Download, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if offset > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+		if ifRange != "" {
+			req.Header.Set("If-Range", ifRange)
+		}
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0 {
+		resp.Body.Close()
+		d := &Download{Body: ioutil.NopCloser(strings.NewReader("")), Offset: offset, Size: -1, pastEnd: true}
+		if _, _, total, err := parseContentRange(resp.Header.Get("Content-Range")); err == nil {
+			d.Size = total
+		}
+		return d, nil
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return nil, newStatusError(url, resp)
+	}
+
+	d := &Download{
+		Body:        resp.Body,
+		ContentType: resp.Header.Get("Content-Type"),
+		Length:      resp.ContentLength,
+		Size:        resp.ContentLength,
+		ETag:        resp.Header.Get("ETag"),
+	}
+	if lm := resp.Header.Get("Last-Modified"); lm != "" {
+		d.LastModified, _ = http.ParseTime(lm)
+	}
+	if resp.StatusCode == http.StatusPartialContent {
+		start, _, total, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("downloading %s: %v", url, err)
+		}
+		d.Offset, d.Size = start, total
+	}
+	return d, nil
+}
+
+// DownloadPhotoFile downloads the photo into the file at path.
+func DownloadPhotoFile(client *http.Client, p Photo, path string) (*Download, error) {
+	return (&Client{Client: client}).DownloadPhotoFile(p, path)
+}
+
+// DownloadPhotoFile downloads p.URL into the file at path, resuming
+// after the already existing content of the file.
+//
+// While the download is incomplete, the validator of the content is kept
+// in path+".validator", and sent as If-Range when resuming, so a changed
+// photo is downloaded from the start. Existing content without a validator
+// is only accepted as the complete file, if its size is the remote size.
+//
+// The final size is checked against the one sent by the server and, for
+// photos, against p.Size (gphoto:size); videos are transcoded, so their
+// size differs from the original's.
+//
+// The returned Download's Body is already closed, and Size is the size
+// of the file.
+func (c *Client) DownloadPhotoFile(p Photo, path string) (*Download, error) {
+	return c.downloadPhotoFile(context.Background(), p, path)
+}
+
+func (c *Client) downloadPhotoFile(ctx context.Context, p Photo, path string) (*Download, error) {
+	fh, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	fi, err := fh.Stat()
+	if err != nil {
+		return nil, err
+	}
+	wantSize := p.Size
+	if p.IsVideo() {
+		wantSize = 0
+	}
+	offset := fi.Size()
+	if wantSize > 0 && offset > wantSize {
+		offset = 0
+	}
+	validatorPath := path + validatorExt
+	var validator string
+	if offset > 0 {
+		if b, err := ioutil.ReadFile(validatorPath); err == nil {
+			validator = string(b)
+		}
+	}
+	d, err := c.downloadRange(ctx, p.URL, offset, validator)
+	if err != nil {
+		return nil, err
+	}
+	if d.pastEnd && d.Size != offset || !d.pastEnd && d.Offset > 0 && validator == "" {
+		// The local file is not the remote one, or a partial download
+		// whose content cannot be validated: start from scratch.
+		d.Body.Close()
+		if d, err = c.downloadRange(ctx, p.URL, 0, ""); err != nil {
+			return nil, err
+		}
+	}
+	defer d.Body.Close()
+
+	if !d.pastEnd {
+		if v := d.validator(); v == "" {
+			os.Remove(validatorPath)
+		} else if v != validator {
+			if err = ioutil.WriteFile(validatorPath, []byte(v), 0640); err != nil {
+				return d, err
+			}
+		}
+	}
+
+	if err = fh.Truncate(d.Offset); err != nil {
+		return d, err
+	}
+	if _, err = fh.Seek(d.Offset, io.SeekStart); err != nil {
+		return d, err
+	}
+	n, err := io.Copy(fh, d.Body)
+	if err != nil {
+		if _, ok := err.(*os.PathError); ok {
+			// Writing the file failed.
+			return d, err
+		}
+		return d, &bodyError{url: p.URL, path: path, err: err}
+	}
+	if err = fh.Close(); err != nil {
+		return d, err
+	}
+	size := d.Offset + n
+	if d.Length >= 0 && n != d.Length {
+		return d, &SizeMismatchError{Path: path, Got: size, Want: d.Offset + d.Length}
+	}
+	if d.Size >= 0 && size != d.Size {
+		return d, &SizeMismatchError{Path: path, Got: size, Want: d.Size}
+	}
+	if wantSize > 0 && size != wantSize {
+		return d, &SizeMismatchError{Path: path, Got: size, Want: wantSize}
+	}
+	d.Size = size
+	if err = os.Remove(validatorPath); err != nil && !os.IsNotExist(err) {
+		return d, err
+	}
+	return d, nil
+}
+
+// validator returns the strong ETag, or the Last-Modified time of the
+// content, usable in If-Range; empty if there is neither.
+func (d *Download) validator() string {
+	if d.ETag != "" && !strings.HasPrefix(d.ETag, "W/") {
+		return d.ETag
+	}
+	if !d.LastModified.IsZero() {
+		return d.LastModified.UTC().Format(http.TimeFormat)
+	}
+	return ""
+}
+
+// parseContentRange parses "bytes 0-99/1234" and "bytes */1234".
+// total is -1 if it is "*".
+func parseContentRange(s string) (start, end, total int64, err error) {
+	if !strings.HasPrefix(s, "bytes ") {
+		return 0, 0, 0, fmt.Errorf("bad Content-Range %q", s)
+	}
+	s = s[len("bytes "):]
+	i := strings.IndexByte(s, '/')
+	if i < 0 {
+		return 0, 0, 0, fmt.Errorf("bad Content-Range %q", s)
+	}
+	rng, tot := s[:i], s[i+1:]
+	total = -1
+	if tot != "*" {
+		if total, err = strconv.ParseInt(tot, 10, 64); err != nil {
+			return 0, 0, 0, fmt.Errorf("bad Content-Range %q: %v", s, err)
+		}
+	}
+	if rng == "*" {
+		return 0, -1, total, nil
+	}
+	j := strings.IndexByte(rng, '-')
+	if j < 0 {
+		return 0, 0, 0, fmt.Errorf("bad Content-Range %q", s)
+	}
+	if start, err = strconv.ParseInt(rng[:j], 10, 64); err != nil {
+		return 0, 0, 0, fmt.Errorf("bad Content-Range %q: %v", s, err)
+	}
+	if end, err = strconv.ParseInt(rng[j+1:], 10, 64); err != nil {
+		return 0, 0, 0, fmt.Errorf("bad Content-Range %q: %v", s, err)
+	}
+	return start, end, total, nil
+}
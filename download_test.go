@@ -0,0 +1,154 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package picago
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDownloadPhotoFile(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	modTime := time.Date(2014, 3, 3, 6, 3, 32, 0, time.UTC)
+	var ranges, ifRanges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		ifRanges = append(ifRanges, r.Header.Get("If-Range"))
+		w.Header().Set("Content-Type", "image/jpeg")
+		http.ServeContent(w, r, "a.jpg", modTime, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "picago-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fn := filepath.Join(dir, "a.jpg")
+	p := Photo{URL: srv.URL + "/a.jpg", Size: int64(len(content))}
+	valid, stale := modTime.Format(http.TimeFormat), modTime.Add(-time.Hour).Format(http.TimeFormat)
+
+	for i, tc := range []struct {
+		prefix     int
+		validator  string
+		wantRanges []string
+		wantOffset int64
+	}{
+		{0, "", []string{""}, 0},
+		{1234, valid, []string{"bytes=1234-"}, 1234},
+		{1234, stale, []string{"bytes=1234-"}, 0},
+		{1234, "", []string{"bytes=1234-", ""}, 0},
+		{len(content), "", []string{"bytes=10000-"}, int64(len(content))},
+		{len(content) + 10, "", []string{""}, 0},
+	} {
+		var prev []byte
+		if tc.prefix > len(content) {
+			prev = append(append(prev, content...), "garbage!!!"...)
+		} else {
+			prev = content[:tc.prefix]
+		}
+		if err := ioutil.WriteFile(fn, prev, 0640); err != nil {
+			t.Fatal(err)
+		}
+		os.Remove(fn + validatorExt)
+		if tc.validator != "" {
+			if err := ioutil.WriteFile(fn+validatorExt, []byte(tc.validator), 0640); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ranges, ifRanges = ranges[:0], ifRanges[:0]
+		d, err := DownloadPhotoFile(srv.Client(), p, fn)
+		if err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		got, err := ioutil.ReadFile(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Errorf("%d. got %d bytes, mismatch", i, len(got))
+		}
+		if d.Size != int64(len(content)) {
+			t.Errorf("%d. Size = %d; want %d", i, d.Size, len(content))
+		}
+		if strings.Join(ranges, ",") != strings.Join(tc.wantRanges, ",") || ifRanges[0] != tc.validator {
+			t.Errorf("%d. ranges = %q, If-Range = %q", i, ranges, ifRanges)
+		}
+		if d.Offset != tc.wantOffset {
+			t.Errorf("%d. Offset = %d, wanted %d", i, d.Offset, tc.wantOffset)
+		}
+		if tc.wantOffset == 1234 && (d.ContentType != "image/jpeg" || !d.LastModified.Equal(modTime)) {
+			t.Errorf("%d. got %+v", i, d)
+		}
+		if _, err = os.Stat(fn + validatorExt); !os.IsNotExist(err) {
+			t.Errorf("%d. validator left behind: %v", i, err)
+		}
+	}
+
+	p.Size++
+	os.Remove(fn)
+	if _, err = DownloadPhotoFile(srv.Client(), p, fn); err == nil {
+		t.Errorf("size mismatch not detected")
+	} else if _, ok := err.(*SizeMismatchError); !ok {
+		t.Errorf("got %v; want SizeMismatchError", err)
+	}
+}
+
+func TestTemporary(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: &os.SyscallError{Syscall: "connect", Err: syscall.ECONNREFUSED}}
+	for i, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{&StatusError{StatusCode: http.StatusServiceUnavailable}, true},
+		{&StatusError{StatusCode: http.StatusTooManyRequests}, true},
+		{&StatusError{StatusCode: http.StatusNotFound}, false},
+		{&bodyError{err: io.ErrUnexpectedEOF}, true},
+		{&url.Error{Op: "Get", URL: "x", Err: refused}, true},
+		{&url.Error{Op: "Get", URL: "x", Err: &net.DNSError{Err: "timeout", IsTimeout: true}}, true},
+		{&url.Error{Op: "Get", URL: "x", Err: context.Canceled}, false},
+		{&url.Error{Op: "Get", URL: "x", Err: context.DeadlineExceeded}, false},
+		{&url.Error{Op: "Get", URL: "x", Err: x509.UnknownAuthorityError{}}, false},
+		{&url.Error{Op: "Get", URL: "x", Err: errors.New("unsupported protocol scheme")}, false},
+		{&SizeMismatchError{}, false},
+	} {
+		if got := temporary(tc.err); got != tc.want {
+			t.Errorf("%d. %v: got %t, wanted %t", i, tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	for _, tc := range []struct {
+		in                string
+		start, end, total int64
+	}{
+		{"bytes 0-99/1234", 0, 99, 1234},
+		{"bytes 100-1233/*", 100, 1233, -1},
+		{"bytes */1234", 0, -1, 1234},
+	} {
+		start, end, total, err := parseContentRange(tc.in)
+		if err != nil || start != tc.start || end != tc.end || total != tc.total {
+			t.Errorf("%q: got %d, %d, %d, %v", tc.in, start, end, total, err)
+		}
+	}
+	if _, _, _, err := parseContentRange(strings.Repeat("x", 3)); err == nil {
+		t.Errorf("no error for garbage")
+	}
+}
@@ -0,0 +1,303 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package picago
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// A Destination returns the local path for a photo of an album.
+type Destination interface {
+	Path(Album, Photo) (string, error)
+}
+
+// DestinationFunc is a function usable as a Destination.
+type DestinationFunc func(Album, Photo) (string, error)
+
+// Path calls f.
+func (f DestinationFunc) Path(a Album, p Photo) (string, error) { return f(a, p) }
+
+// NameDestination returns a Destination which puts the photos into
+// dir/album.Name/photo.Filename, creating the album's directory.
+// Both names are sanitised (see SanitiseName), so they stay under dir.
+func NameDestination(dir string) Destination {
+	return DestinationFunc(func(a Album, p Photo) (string, error) {
+		albumDir := filepath.Join(dir, SanitiseName(a.Name))
+		if err := os.MkdirAll(albumDir, 0750); err != nil {
+			return "", err
+		}
+		return filepath.Join(albumDir, SanitiseName(p.Filename)), nil
+	})
+}
+
+// A PathRenderer is a Destination which can tell the path of a photo
+// without creating anything. Downloader.Plan uses Render instead of Path,
+// if the Destination implements it.
+type PathRenderer interface {
+	Destination
+	Render(Album, Photo) (string, error)
+}
+
+// A DownloadJob is a photo to be downloaded by a Downloader.
+type DownloadJob struct {
+	Album Album
+	Photo Photo
+
+	// Changed is true if the photo has changed since it was downloaded
+	// last time, so its copy in the Dedupe store is not reused.
+	Changed bool
+}
+
+// A DownloadResult is the outcome of a DownloadJob.
+type DownloadResult struct {
+	DownloadJob
+
+	// Path is where the photo has been written to.
+	Path string
+
+	// Download is the metadata of the (last) response, if any.
+	Download *Download
+
+	// Attempts is the number of tries made.
+	Attempts int
+
+	// Deduped is true if an already stored copy has been reused instead
+	// of downloading; Download is nil then.
+	Deduped bool
+
+	// Err is the error of the last attempt, nil on success.
+	Err error
+}
+
+// A Downloader downloads photos concurrently.
+type Downloader struct {
+	Client *Client
+
+	// Destination says where to put the photos. Required.
+	Destination Destination
+
+	// Concurrency is the number of parallel downloads, 4 by default.
+	Concurrency int
+
+	// PerHost limits the parallel downloads from the same host;
+	// zero means no limit beyond Concurrency.
+	PerHost int
+
+	// Retries is the number of retries after a download failed for a
+	// network or server error (5xx, 429).
+	Retries int
+
+	// RetryDelay is the wait before the first retry, doubled before each
+	// subsequent one. One second by default.
+	RetryDelay time.Duration
+
+	// Selector chooses the variant to download; if nil, Photo.URL is used.
+	Selector MediaSelector
+
+	// EmbedMetadata makes the JPEGs carry the photo's caption, keywords
+	// and position (see EmbedJPEGMetadata). They are downloaded into
+	// path+".part" first, so an interrupted download can be resumed.
+	EmbedMetadata bool
+
+	// TimeOrder, if not empty, is the priority of the photo's timestamps
+	// to set the downloaded file's modification time from.
+	TimeOrder []TimeSource
+
+	// Dedupe, if not nil, stores each distinct photo only once.
+	// Photos are downloaded into path+".part" first then.
+	Dedupe *Deduper
+
+	// Log, if not nil, is called with key-value pairs to warn about
+	// problems which don't fail the download.
+	Log func(...interface{}) error
+
+	mu    sync.Mutex
+	hosts map[string]chan struct{}
+}
+
+// Run downloads the photos received from jobs, until jobs is closed or
+// ctx is cancelled. A result is sent for every job received; the returned
+// channel is closed after the last one.
+func (d *Downloader) Run(ctx context.Context, jobs <-chan DownloadJob) <-chan DownloadResult {
+	n := d.Concurrency
+	if n <= 0 {
+		n = 4
+	}
+	results := make(chan DownloadResult, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				results <- d.download(ctx, job)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
+
+// Download downloads the given photos and returns their results,
+// in the order of completion.
+func (d *Downloader) Download(ctx context.Context, jobs []DownloadJob) []DownloadResult {
+	ch := make(chan DownloadJob)
+	go func() {
+		defer close(ch)
+		for _, job := range jobs {
+			select {
+			case ch <- job:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	results := make([]DownloadResult, 0, len(jobs))
+	for res := range d.Run(ctx, ch) {
+		results = append(results, res)
+	}
+	return results
+}
+
+func (d *Downloader) download(ctx context.Context, job DownloadJob) DownloadResult {
+	res := DownloadResult{DownloadJob: job}
+	p, err := d.variant(job.Photo)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	if res.Path, res.Err = d.Destination.Path(job.Album, p); res.Err != nil {
+		return res
+	}
+	path := res.Path
+	embed := d.EmbedMetadata && p.Type == "image/jpeg"
+	if embed || d.Dedupe != nil {
+		path += ".part"
+	}
+	if d.Dedupe != nil && !job.Changed {
+		if res.Deduped, res.Err = d.Dedupe.Reuse(res.Path, p); res.Deduped || res.Err != nil {
+			return res
+		}
+	}
+	delay := d.RetryDelay
+	if delay <= 0 {
+		delay = time.Second
+	}
+	for {
+		if res.Err = ctx.Err(); res.Err != nil {
+			return res
+		}
+		res.Attempts++
+		release := d.acquireHost(ctx, p.URL)
+		res.Download, res.Err = d.Client.downloadPhotoFile(ctx, p, path)
+		release()
+		if res.Err == nil || res.Attempts > d.Retries || ctx.Err() != nil || !temporary(res.Err) {
+			break
+		}
+		select {
+		case <-time.After(delay):
+			delay *= 2
+		case <-ctx.Done():
+			return res
+		}
+	}
+	if res.Err == nil && embed {
+		res.Err = d.embedFile(res.Path, path, p)
+	} else if res.Err == nil && path != res.Path {
+		res.Err = os.Rename(path, res.Path)
+	}
+	if res.Err == nil && len(d.TimeOrder) != 0 {
+		res.Err = SetPhotoTime(res.Path, p, d.TimeOrder)
+	}
+	if res.Err == nil && d.Dedupe != nil {
+		res.Err = d.Dedupe.Add(res.Path, p)
+	}
+	return res
+}
+
+// variant returns the photo with the URL, type and dimensions of the
+// variant chosen by the Selector. The size is of the original only, so
+// it is unknown for another variant.
+func (d *Downloader) variant(p Photo) (Photo, error) {
+	if d.Selector == nil {
+		return p, nil
+	}
+	mc, ok := p.Select(d.Selector)
+	if !ok {
+		return p, errNoVariant(p.ID)
+	}
+	if mc.URL != p.URL {
+		p.Size = 0
+	}
+	p.URL, p.Type, p.Width, p.Height = mc.URL, mc.Type, mc.Width, mc.Height
+	return p, nil
+}
+
+// embedFile embeds the photo's metadata into the JPEG downloaded to part,
+// writing it to path. If the metadata cannot be embedded, the JPEG is
+// kept as downloaded.
+func (d *Downloader) embedFile(path, part string, p Photo) error {
+	b, err := ioutil.ReadFile(part)
+	if err != nil {
+		return err
+	}
+	if b, err = EmbedJPEGMetadata(b, p); err != nil {
+		if d.Log != nil {
+			d.Log("msg", "cannot embed metadata, keeping the photo as is", "path", path, "error", err)
+		}
+		return os.Rename(part, path)
+	}
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0640); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return os.Remove(part)
+}
+
+// acquireHost waits for a free slot of the host of rawurl,
+// and returns the function to release it.
+func (d *Downloader) acquireHost(ctx context.Context, rawurl string) func() {
+	if d.PerHost <= 0 {
+		return func() {}
+	}
+	var host string
+	if u, err := url.Parse(rawurl); err == nil {
+		host = u.Host
+	}
+	d.mu.Lock()
+	if d.hosts == nil {
+		d.hosts = make(map[string]chan struct{})
+	}
+	sem := d.hosts[host]
+	if sem == nil {
+		sem = make(chan struct{}, d.PerHost)
+		d.hosts[host] = sem
+	}
+	d.mu.Unlock()
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }
+	case <-ctx.Done():
+		return func() {}
+	}
+}
+
+type errNoVariant string
+
+func (e errNoVariant) Error() string {
+	return "photo " + string(e) + ": no acceptable media variant"
+}
@@ -0,0 +1,248 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package picago
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDownloader(t *testing.T) {
+	var (
+		mu              sync.Mutex
+		active, maxSeen int
+		tries           = make(map[string]int)
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		active++
+		if active > maxSeen {
+			maxSeen = active
+		}
+		tries[r.URL.Path]++
+		n := tries[r.URL.Path]
+		mu.Unlock()
+		defer func() { mu.Lock(); active--; mu.Unlock() }()
+
+		time.Sleep(10 * time.Millisecond)
+		if r.URL.Path == "/flaky" && n == 1 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "picago-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d := Downloader{
+		Client:      &Client{Client: srv.Client()},
+		Destination: NameDestination(dir),
+		Concurrency: 4,
+		PerHost:     2,
+		Retries:     1,
+		RetryDelay:  time.Millisecond,
+	}
+	album := Album{ID: "1", Name: "album"}
+	var jobs []DownloadJob
+	for i := 0; i < 6; i++ {
+		name := "p" + strconv.Itoa(i)
+		jobs = append(jobs, DownloadJob{Album: album, Photo: Photo{ID: name, Filename: name, URL: srv.URL + "/" + name}})
+	}
+	jobs = append(jobs,
+		DownloadJob{Album: album, Photo: Photo{ID: "flaky", Filename: "flaky", URL: srv.URL + "/flaky"}},
+		DownloadJob{Album: album, Photo: Photo{ID: "missing", Filename: "missing", URL: srv.URL + "/missing"}},
+	)
+
+	results := d.Download(context.Background(), jobs)
+	if len(results) != len(jobs) {
+		t.Fatalf("got %d results, wanted %d", len(results), len(jobs))
+	}
+	for _, res := range results {
+		switch res.Photo.ID {
+		case "missing":
+			if res.Err == nil || res.Attempts != 1 {
+				t.Errorf("%s: got %d attempts, err=%v; wanted 1 attempt and an error", res.Photo.ID, res.Attempts, res.Err)
+			}
+			continue
+		case "flaky":
+			if res.Attempts != 2 {
+				t.Errorf("%s: got %d attempts, wanted 2", res.Photo.ID, res.Attempts)
+			}
+		}
+		if res.Err != nil {
+			t.Errorf("%s: %v", res.Photo.ID, res.Err)
+			continue
+		}
+		if want := filepath.Join(dir, album.Name, res.Photo.Filename); res.Path != want {
+			t.Errorf("%s: got path %q, wanted %q", res.Photo.ID, res.Path, want)
+		}
+		b, err := ioutil.ReadFile(res.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(b), "/"+res.Photo.ID; got != want {
+			t.Errorf("%s: got %q, wanted %q", res.Photo.ID, got, want)
+		}
+	}
+	if maxSeen > d.PerHost {
+		t.Errorf("got %d parallel requests, wanted at most %d", maxSeen, d.PerHost)
+	}
+}
+
+func TestDownloaderVariant(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "picago-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d := Downloader{
+		Client:      &Client{Client: srv.Client()},
+		Destination: NameDestination(dir),
+		Selector:    MaxDimension(720),
+	}
+	// The size is of the original, not of the selected variant.
+	p := Photo{ID: "1", Filename: "a.jpg", URL: srv.URL + "/orig", Size: 5000,
+		Media: []MediaContent{
+			{URL: srv.URL + "/orig", Type: "image/jpeg", Medium: "image", Width: 2000, Height: 1500},
+			{URL: srv.URL + "/small", Type: "image/jpeg", Medium: "image", Width: 720, Height: 540},
+		},
+	}
+	results := d.Download(context.Background(), []DownloadJob{{Album: Album{ID: "1", Name: "album"}, Photo: p}})
+	if res := results[0]; res.Err != nil {
+		t.Fatal(res.Err)
+	}
+	if b, err := ioutil.ReadFile(results[0].Path); err != nil || string(b) != "/small" {
+		t.Errorf("got %q (%v), wanted the small variant", b, err)
+	}
+}
+
+func TestNameDestination(t *testing.T) {
+	dir, err := ioutil.TempDir("", "picago-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path, err := NameDestination(dir).Path(Album{Name: ".."}, Photo{Filename: "../../.bashrc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "_", ".._.._.bashrc"); path != want {
+		t.Errorf("got %q, wanted %q", path, want)
+	}
+}
+
+func TestFileTimes(t *testing.T) {
+	if _, err := ParseTimeOrder("exif,nope"); err == nil {
+		t.Error("no error for unknown time source")
+	}
+	order, err := ParseTimeOrder("exif, Timestamp,published")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(order) != 3 || order[1] != TimeTimestamp {
+		t.Fatalf("got %q", order)
+	}
+
+	published := time.Date(2014, 7, 21, 7, 0, 0, 0, time.UTC)
+	stamp := time.Date(2014, 7, 20, 0, 0, 0, 0, time.UTC)
+	ms := time.Date(2014, 7, 19, 12, 30, 0, 0, time.UTC).UnixNano() / int64(time.Millisecond)
+	for _, tc := range []struct {
+		p    Photo
+		want time.Time
+	}{
+		{Photo{Published: published}, published},
+		{Photo{Published: published, Timestamp: stamp}, stamp},
+		{Photo{Published: published, Timestamp: stamp, Exif: Exif{Timestamp: &ms}}, time.Unix(0, ms*int64(time.Millisecond))},
+	} {
+		if got, ok := tc.p.Time(order); !ok || !got.Equal(tc.want) {
+			t.Errorf("got %v (%t), wanted %v", got, ok, tc.want)
+		}
+	}
+	if _, ok := (Photo{}).Time(order); ok {
+		t.Error("zero photo has time")
+	}
+
+	dir, err := ioutil.TempDir("", "picago-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fn := filepath.Join(dir, "a.jpg")
+	if err = ioutil.WriteFile(fn, nil, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err = SetPhotoTime(fn, Photo{Published: published, Timestamp: stamp}, []TimeSource{TimePublished}); err != nil {
+		t.Fatal(err)
+	}
+	if err = SetAlbumTime(dir, Album{Timestamp: stamp}, order); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]time.Time{fn: published, dir: stamp} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fi.ModTime().Equal(want) {
+			t.Errorf("%s: got %v, wanted %v", path, fi.ModTime(), want)
+		}
+	}
+}
+
+func TestDownloaderEmbedFallback(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("not a JPEG"))
+	}))
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "picago-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var warnings int
+	d := Downloader{
+		Client:        &Client{Client: srv.Client()},
+		Destination:   NameDestination(dir),
+		EmbedMetadata: true,
+		Log:           func(...interface{}) error { warnings++; return nil },
+	}
+	p := Photo{ID: "1", Filename: "a.jpg", Type: "image/jpeg", Description: "sea", URL: srv.URL + "/1"}
+	res := d.Download(context.Background(), []DownloadJob{{Album: Album{Name: "album"}, Photo: p}})[0]
+	if res.Err != nil {
+		t.Fatal(res.Err)
+	}
+	if b, err := ioutil.ReadFile(res.Path); err != nil || string(b) != "not a JPEG" {
+		t.Errorf("got %q, %v", b, err)
+	}
+	if _, err = os.Stat(res.Path + ".part"); !os.IsNotExist(err) {
+		t.Errorf("%s.part left behind: %v", res.Path, err)
+	}
+	if warnings != 1 {
+		t.Errorf("got %d warnings, wanted 1", warnings)
+	}
+}
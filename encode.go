@@ -0,0 +1,380 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package picago
+
+import (
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// namespaces are the prefixes declared on the root element,
+// and used for the elements written by MarshalXML.
+var namespaces = []xml.Attr{
+	{Name: xml.Name{Local: "xmlns"}, Value: atomNS},
+	{Name: xml.Name{Local: "xmlns:gphoto"}, Value: gphotoNS},
+	{Name: xml.Name{Local: "xmlns:media"}, Value: mediaNS},
+	{Name: xml.Name{Local: "xmlns:exif"}, Value: exifNS},
+	{Name: xml.Name{Local: "xmlns:georss"}, Value: georssNS},
+	{Name: xml.Name{Local: "xmlns:gml"}, Value: gmlNS},
+	{Name: xml.Name{Local: "xmlns:gd"}, Value: gdNS},
+	{Name: xml.Name{Local: "xmlns:openSearch"}, Value: openSearchNS},
+}
+
+// MarshalXML writes the feed as Atom XML, readable by ParseAtom.
+func (a Atom) MarshalXML(enc *xml.Encoder, _ xml.StartElement) error {
+	w := xmlWriter{enc: enc}
+	w.start("feed", namespaces...)
+	w.text("id", a.ID)
+	w.time("updated", a.Updated)
+	w.text("title", a.Title)
+	w.text("subtitle", a.Subtitle)
+	w.text("icon", a.Icon)
+	for _, c := range a.Categories {
+		w.empty("category", attr("scheme", c.Scheme), attr("term", c.Term))
+	}
+	w.author(a.Author)
+	w.text("gphoto:name", a.Name)
+	w.text("gphoto:thumbnail", a.Thumbnail)
+	w.int("gphoto:numphotos", int64(a.NumPhotos))
+	w.int("openSearch:totalResults", int64(a.TotalResults))
+	w.int("openSearch:startIndex", int64(a.StartIndex))
+	w.int("openSearch:itemsPerPage", int64(a.ItemsPerPage))
+	for i := range a.Entries {
+		if w.err == nil {
+			w.err = a.Entries[i].MarshalXML(enc, xml.StartElement{})
+		}
+	}
+	w.end("feed")
+	return w.flush()
+}
+
+// MarshalXML writes the entry as Atom XML with the gphoto, media, exif and
+// georss extensions, readable by Entry.DecodeReader and (in a feed) by
+// ParseAtom.
+func (e Entry) MarshalXML(enc *xml.Encoder, _ xml.StartElement) error {
+	w := xmlWriter{enc: enc}
+	attrs := namespaces
+	if e.ETag != "" {
+		attrs = append(attrs[:len(attrs):len(attrs)],
+			xml.Attr{Name: xml.Name{Local: "gd:etag"}, Value: e.ETag})
+	}
+	w.start("entry", attrs...)
+	w.text("id", e.EntryID)
+	w.time("published", e.Published)
+	w.time("updated", e.Updated)
+	for _, c := range e.Categories {
+		w.empty("category", attr("scheme", c.Scheme), attr("term", c.Term))
+	}
+	w.text("title", e.Title)
+	w.text("summary", e.Summary)
+	w.text("rights", e.Rights)
+	if e.Content != (EntryContent{}) {
+		w.start("content", nonEmpty(attr("type", e.Content.Type), attr("src", e.Content.URL))...)
+		w.token(xml.CharData(e.Content.Text))
+		w.end("content")
+	}
+	for _, l := range e.Links {
+		w.empty("link", attr("rel", l.Rel), attr("type", l.Type), attr("href", l.URL))
+	}
+	w.author(e.Author)
+
+	w.text("gphoto:id", e.ID)
+	w.text("gphoto:name", e.Name)
+	w.text("gphoto:albumType", e.AlbumType)
+	w.text("gphoto:location", e.Location)
+	w.text("gphoto:access", e.Access)
+	w.int("gphoto:timestamp", e.Timestamp)
+	w.int("gphoto:numphotos", int64(e.NumPhotos))
+	w.int("gphoto:numphotosremaining", int64(e.NumPhotosRemaining))
+	w.int("gphoto:bytesUsed", e.BytesUsed)
+	if e.CommentingEnabled {
+		w.text("gphoto:commentingEnabled", "true")
+	}
+	w.boolPtr("gphoto:allowDownloads", e.AllowDownloads)
+	w.boolPtr("gphoto:allowPrints", e.AllowPrints)
+	w.text("gphoto:albumid", e.AlbumID)
+	w.int("gphoto:version", e.Version)
+	w.text("gphoto:checksum", e.Checksum)
+	w.int("gphoto:size", e.Size)
+	w.int("gphoto:rotation", int64(e.Rotation))
+	w.int("gphoto:commentCount", int64(e.CommentCount))
+	w.text("gphoto:photoid", e.PhotoID)
+	w.int("gphoto:weight", int64(e.Weight))
+	w.text("gphoto:user", e.User)
+	w.text("gphoto:nickname", e.Nickname)
+	w.text("gphoto:thumbnail", e.Thumbnail)
+
+	if x := e.Exif; x != nil {
+		w.start("exif:tags")
+		w.text("exif:make", x.Make)
+		w.text("exif:model", x.Model)
+		w.text("exif:lens", x.Lens)
+		w.floatPtr("exif:fstop", x.FStop)
+		w.floatPtr("exif:exposure", x.Exposure)
+		w.floatPtr("exif:focallength", x.FocalLength)
+		w.floatPtr("exif:distance", x.Distance)
+		if x.ISO != nil {
+			w.number("exif:iso", int64(*x.ISO))
+		}
+		w.boolPtr("exif:flash", x.Flash)
+		if x.Orientation != nil {
+			w.number("exif:orientation", int64(*x.Orientation))
+		}
+		if x.Timestamp != nil {
+			w.number("exif:time", *x.Timestamp)
+		}
+		w.text("exif:imageUniqueID", x.UID)
+		w.floatPtr("exif:gpsLatitude", x.Latitude)
+		w.floatPtr("exif:gpsLongitude", x.Longitude)
+		w.floatPtr("exif:gpsAltitude", x.Altitude)
+		w.end("exif:tags")
+	}
+
+	if m := e.Media; m != nil {
+		w.start("media:group")
+		w.text("media:title", m.Title)
+		w.text("media:description", m.Description)
+		w.text("media:keywords", m.Keywords)
+		for _, mc := range m.Content {
+			w.mediaContent("media:content", mc)
+		}
+		for _, mc := range m.Thumbnail {
+			w.mediaContent("media:thumbnail", mc)
+		}
+		w.end("media:group")
+	}
+
+	if e.Point != "" || e.LowerCorner != "" || e.UpperCorner != "" {
+		w.start("georss:where")
+		if e.Point != "" {
+			w.start("gml:Point")
+			w.text("gml:pos", e.Point)
+			w.end("gml:Point")
+		}
+		if e.LowerCorner != "" || e.UpperCorner != "" {
+			w.start("gml:Envelope")
+			w.text("gml:lowerCorner", e.LowerCorner)
+			w.text("gml:upperCorner", e.UpperCorner)
+			w.end("gml:Envelope")
+		}
+		w.end("georss:where")
+	}
+	w.end("entry")
+	return w.flush()
+}
+
+// Entry returns the Atom entry describing the album.
+func (a Album) Entry() *Entry {
+	e := &Entry{
+		ETag:       a.ETag,
+		ID:         a.ID,
+		Name:       a.Name,
+		Title:      a.Title,
+		AlbumType:  a.AlbumType,
+		Rights:     a.Rights,
+		Summary:    a.Description,
+		Location:   a.Location,
+		Published:  a.Published,
+		Updated:    a.Updated,
+		Author:     Author{Name: a.AuthorName, URI: a.AuthorURI},
+		Categories: []Category{KindAlbum.Category()},
+
+		NumPhotos:          a.NumPhotos,
+		NumPhotosRemaining: a.NumPhotosRemaining,
+		BytesUsed:          a.BytesUsed,
+		Access:             a.Access,
+		Timestamp:          timeMsec(a.Timestamp),
+		CommentingEnabled:  a.CommentingEnabled,
+		AllowDownloads:     &a.AllowDownloads,
+		AllowPrints:        &a.AllowPrints,
+	}
+	if a.URL != "" {
+		e.Links = append(e.Links, Link{Rel: "alternate", Type: "text/html", URL: a.URL})
+	}
+	if a.Cover.URL != "" {
+		e.Media = &Media{Thumbnail: []MediaContent{{URL: a.Cover.URL, Width: a.Cover.Width, Height: a.Cover.Height}}}
+	}
+	e.setGeo(a.Point, a.Box)
+	return e
+}
+
+// Entry returns the Atom entry describing the photo.
+//
+// Position is not part of the entry, and Latitude and Longitude are
+// only used if Point is nil.
+func (p Photo) Entry() *Entry {
+	e := &Entry{
+		ETag:       p.ETag,
+		ID:         p.ID,
+		Title:      p.Filename,
+		Summary:    p.Description,
+		Location:   p.Location,
+		Published:  p.Published,
+		Updated:    p.Updated,
+		Categories: []Category{KindPhoto.Category()},
+		Content:    EntryContent{URL: p.URL, Type: p.Type},
+
+		AlbumID:      p.AlbumID,
+		Checksum:     p.Checksum,
+		Size:         p.Size,
+		Rotation:     p.Rotation,
+		CommentCount: p.CommentCount,
+		Version:      p.Version,
+		Timestamp:    timeMsec(p.Timestamp),
+	}
+	if p.PageURL != "" {
+		e.Links = append(e.Links, Link{Rel: "alternate", Type: "text/html", URL: p.PageURL})
+	}
+	if !p.Exif.IsZero() {
+		x := p.Exif
+		e.Exif = &x
+	}
+	if len(p.Media) != 0 || p.URL != "" || len(p.Thumbnails) != 0 || len(p.Keywords) != 0 {
+		m := &Media{Keywords: strings.Join(p.Keywords, ", "), Content: p.Media}
+		if len(m.Content) == 0 && p.URL != "" {
+			m.Content = []MediaContent{{URL: p.URL, Type: p.Type, Width: p.Width, Height: p.Height}}
+		}
+		for _, t := range p.Thumbnails {
+			m.Thumbnail = append(m.Thumbnail, MediaContent{URL: t.URL, Width: t.Width, Height: t.Height})
+		}
+		e.Media = m
+	}
+	point := p.Point
+	if point == nil && (p.Latitude != 0 || p.Longitude != 0) {
+		point = &GeoPoint{Latitude: p.Latitude, Longitude: p.Longitude}
+	}
+	e.setGeo(point, p.Box)
+	return e
+}
+
+func (e *Entry) setGeo(point *GeoPoint, box *GeoBox) {
+	if point != nil {
+		e.Point = point.String()
+	}
+	if box != nil {
+		e.LowerCorner, e.UpperCorner = box.Lower.String(), box.Upper.String()
+	}
+}
+
+// timeMsec is the inverse of msecTime.
+func timeMsec(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func attr(name, value string) xml.Attr {
+	return xml.Attr{Name: xml.Name{Local: name}, Value: value}
+}
+
+// xmlWriter writes prefixed elements, remembering the first error.
+type xmlWriter struct {
+	enc *xml.Encoder
+	err error
+}
+
+func (w *xmlWriter) token(t xml.Token) {
+	if w.err == nil {
+		w.err = w.enc.EncodeToken(t)
+	}
+}
+
+func (w *xmlWriter) start(name string, attrs ...xml.Attr) {
+	w.token(xml.StartElement{Name: xml.Name{Local: name}, Attr: attrs})
+}
+
+func (w *xmlWriter) end(name string) {
+	w.token(xml.EndElement{Name: xml.Name{Local: name}})
+}
+
+// empty writes an element without content, with the non-empty attributes.
+func (w *xmlWriter) empty(name string, attrs ...xml.Attr) {
+	w.start(name, nonEmpty(attrs...)...)
+	w.end(name)
+}
+
+func nonEmpty(attrs ...xml.Attr) []xml.Attr {
+	res := attrs[:0:0]
+	for _, a := range attrs {
+		if a.Value != "" {
+			res = append(res, a)
+		}
+	}
+	return res
+}
+
+// text writes the element with the given text, if it is not empty.
+func (w *xmlWriter) text(name, value string) {
+	if value == "" {
+		return
+	}
+	w.start(name)
+	w.token(xml.CharData(value))
+	w.end(name)
+}
+
+// int writes the element if i is not zero.
+func (w *xmlWriter) int(name string, i int64) {
+	if i != 0 {
+		w.number(name, i)
+	}
+}
+
+func (w *xmlWriter) number(name string, i int64) {
+	w.start(name)
+	w.token(xml.CharData(strconv.FormatInt(i, 10)))
+	w.end(name)
+}
+
+func (w *xmlWriter) time(name string, t time.Time) {
+	if !t.IsZero() {
+		w.text(name, t.Format(time.RFC3339Nano))
+	}
+}
+
+func (w *xmlWriter) boolPtr(name string, b *bool) {
+	if b != nil {
+		w.start(name)
+		w.token(xml.CharData(strconv.FormatBool(*b)))
+		w.end(name)
+	}
+}
+
+func (w *xmlWriter) floatPtr(name string, f *float64) {
+	if f != nil {
+		w.start(name)
+		w.token(xml.CharData(strconv.FormatFloat(*f, 'g', -1, 64)))
+		w.end(name)
+	}
+}
+
+func (w *xmlWriter) author(a Author) {
+	if a == (Author{}) {
+		return
+	}
+	w.start("author")
+	w.text("name", a.Name)
+	w.text("uri", a.URI)
+	w.end("author")
+}
+
+func (w *xmlWriter) mediaContent(name string, mc MediaContent) {
+	attrs := []xml.Attr{attr("url", mc.URL), attr("type", mc.Type), attr("medium", mc.Medium)}
+	if mc.Width != 0 || mc.Height != 0 {
+		attrs = append(attrs,
+			attr("width", strconv.Itoa(mc.Width)), attr("height", strconv.Itoa(mc.Height)))
+	}
+	w.empty(name, attrs...)
+}
+
+func (w *xmlWriter) flush() error {
+	if w.err == nil {
+		w.err = w.enc.Flush()
+	}
+	return w.err
+}
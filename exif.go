@@ -0,0 +1,92 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package picago
+
+import (
+	"math"
+	"strconv"
+	"time"
+)
+
+// Exif contains the exif:tags of a photo.
+//
+// The numeric fields are pointers, nil when the tag is missing, to make
+// them distinguishable from a real zero (e.g. ISO or Flash).
+type Exif struct {
+	// Make and Model of the camera.
+	Make  string `xml:"make"`
+	Model string `xml:"model"`
+
+	// Lens is the lens model, if known.
+	Lens string `xml:"lens"`
+
+	// FStop is the aperture as f-number, e.g. 2.8.
+	FStop *float64 `xml:"fstop"`
+
+	// Exposure is the exposure time in seconds.
+	Exposure *float64 `xml:"exposure"`
+
+	// FocalLength is the real focal length in millimetres.
+	FocalLength *float64 `xml:"focallength"`
+
+	// Distance is the subject distance in metres.
+	Distance *float64 `xml:"distance"`
+
+	ISO   *int  `xml:"iso"`
+	Flash *bool `xml:"flash"`
+
+	// Orientation is the EXIF orientation (1-8).
+	Orientation *int `xml:"orientation"`
+
+	// Timestamp is the capture time in milliseconds since the Unix
+	// epoch, as recorded by the camera. See Time.
+	Timestamp *int64 `xml:"time"`
+
+	// UID is the unique ID of the image, as set by the camera.
+	UID string `xml:"imageUniqueID"`
+
+	// Latitude, Longitude (in degrees) and Altitude (in metres) are
+	// the GPS position recorded by the camera.
+	Latitude  *float64 `xml:"gpsLatitude"`
+	Longitude *float64 `xml:"gpsLongitude"`
+	Altitude  *float64 `xml:"gpsAltitude"`
+}
+
+// IsZero reports whether none of the tags are set.
+func (e Exif) IsZero() bool {
+	return e == Exif{}
+}
+
+// Time returns the capture time, and false if it is unknown (missing or 0).
+func (e Exif) Time() (time.Time, bool) {
+	if e.Timestamp == nil || *e.Timestamp == 0 {
+		return time.Time{}, false
+	}
+	return msecTime(*e.Timestamp), true
+}
+
+// ExposureString returns the exposure time as a photographer would write
+// it: "1/125" for fractions of a second, "2.5" for longer exposures.
+// It returns the empty string if the exposure is unknown.
+func (e Exif) ExposureString() string {
+	if e.Exposure == nil || *e.Exposure <= 0 {
+		return ""
+	}
+	exp := *e.Exposure
+	if exp >= 1 {
+		return strconv.FormatFloat(exp, 'f', -1, 64)
+	}
+	return "1/" + strconv.FormatFloat(math.Round(1/exp), 'f', -1, 64)
+}
+
+// FocalLength35mm returns the 35 mm equivalent focal length, using the
+// given crop factor of the camera's sensor. Picasa does not record the
+// sensor size, so the caller must know the crop factor (1 for full frame).
+func (e Exif) FocalLength35mm(cropFactor float64) (float64, bool) {
+	if e.FocalLength == nil || cropFactor <= 0 {
+		return 0, false
+	}
+	return math.Round(*e.FocalLength*cropFactor*10) / 10, true
+}
@@ -0,0 +1,614 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package picago
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// An Expr is a parsed selection expression, see ParseExpr.
+type Expr struct {
+	src  string
+	root exprNode
+}
+
+// An ExprError is a syntax or type error of an expression,
+// Pos is the byte offset of its location.
+type ExprError struct {
+	Pos int
+	Msg string
+}
+
+func (e *ExprError) Error() string { return fmt.Sprintf("position %d: %s", e.Pos+1, e.Msg) }
+
+// exprType is the type of a value of an expression.
+type exprType int
+
+const (
+	tBool exprType = iota
+	tNumber
+	tString
+	tTime
+	tList
+)
+
+func (t exprType) String() string {
+	return [...]string{"bool", "number", "string", "time", "list"}[t]
+}
+
+// An exprField is a field of an Album, Photo or Exif usable in expressions.
+// album fields need no Photo.
+type exprField struct {
+	typ   exprType
+	album bool
+	get   func(*Album, *Photo) interface{}
+}
+
+func optNumber(f *float64) float64 {
+	if f == nil {
+		return math.NaN()
+	}
+	return *f
+}
+
+func optInt(i *int) float64 {
+	if i == nil {
+		return math.NaN()
+	}
+	return float64(*i)
+}
+
+var exprFields = map[string]exprField{
+	"album.id":          {tString, true, func(a *Album, _ *Photo) interface{} { return a.ID }},
+	"album.name":        {tString, true, func(a *Album, _ *Photo) interface{} { return a.Name }},
+	"album.title":       {tString, true, func(a *Album, _ *Photo) interface{} { return a.Title }},
+	"album.type":        {tString, true, func(a *Album, _ *Photo) interface{} { return a.AlbumType }},
+	"album.rights":      {tString, true, func(a *Album, _ *Photo) interface{} { return a.Rights }},
+	"album.access":      {tString, true, func(a *Album, _ *Photo) interface{} { return a.Access }},
+	"album.description": {tString, true, func(a *Album, _ *Photo) interface{} { return a.Description }},
+	"album.location":    {tString, true, func(a *Album, _ *Photo) interface{} { return a.Location }},
+	"album.published":   {tTime, true, func(a *Album, _ *Photo) interface{} { return a.Published }},
+	"album.updated":     {tTime, true, func(a *Album, _ *Photo) interface{} { return a.Updated }},
+	"album.timestamp":   {tTime, true, func(a *Album, _ *Photo) interface{} { return a.Timestamp }},
+	"album.photos":      {tNumber, true, func(a *Album, _ *Photo) interface{} { return float64(a.NumPhotos) }},
+	"album.bytes":       {tNumber, true, func(a *Album, _ *Photo) interface{} { return float64(a.BytesUsed) }},
+
+	"id":          {tString, false, func(_ *Album, p *Photo) interface{} { return p.ID }},
+	"filename":    {tString, false, func(_ *Album, p *Photo) interface{} { return p.Filename }},
+	"description": {tString, false, func(_ *Album, p *Photo) interface{} { return p.Description }},
+	"keywords":    {tList, false, func(_ *Album, p *Photo) interface{} { return p.Keywords }},
+	"location":    {tString, false, func(_ *Album, p *Photo) interface{} { return p.Location }},
+	"type":        {tString, false, func(_ *Album, p *Photo) interface{} { return p.Type }},
+	"video":       {tBool, false, func(_ *Album, p *Photo) interface{} { return p.IsVideo() }},
+	"width":       {tNumber, false, func(_ *Album, p *Photo) interface{} { return float64(p.Width) }},
+	"height":      {tNumber, false, func(_ *Album, p *Photo) interface{} { return float64(p.Height) }},
+	"size":        {tNumber, false, func(_ *Album, p *Photo) interface{} { return float64(p.Size) }},
+	"published":   {tTime, false, func(_ *Album, p *Photo) interface{} { return p.Published }},
+	"updated":     {tTime, false, func(_ *Album, p *Photo) interface{} { return p.Updated }},
+	"timestamp":   {tTime, false, func(_ *Album, p *Photo) interface{} { return p.Timestamp }},
+	"taken":       {tTime, false, func(_ *Album, p *Photo) interface{} { return p.taken() }},
+	"year": {tNumber, false, func(_ *Album, p *Photo) interface{} {
+		if t := p.taken(); !t.IsZero() {
+			return float64(t.Year())
+		}
+		return math.NaN()
+	}},
+
+	"exif.make":        {tString, false, func(_ *Album, p *Photo) interface{} { return p.Exif.Make }},
+	"exif.model":       {tString, false, func(_ *Album, p *Photo) interface{} { return p.Exif.Model }},
+	"exif.lens":        {tString, false, func(_ *Album, p *Photo) interface{} { return p.Exif.Lens }},
+	"exif.uid":         {tString, false, func(_ *Album, p *Photo) interface{} { return p.Exif.UID }},
+	"exif.fstop":       {tNumber, false, func(_ *Album, p *Photo) interface{} { return optNumber(p.Exif.FStop) }},
+	"exif.exposure":    {tNumber, false, func(_ *Album, p *Photo) interface{} { return optNumber(p.Exif.Exposure) }},
+	"exif.focallength": {tNumber, false, func(_ *Album, p *Photo) interface{} { return optNumber(p.Exif.FocalLength) }},
+	"exif.iso":         {tNumber, false, func(_ *Album, p *Photo) interface{} { return optInt(p.Exif.ISO) }},
+	"exif.flash": {tBool, false, func(_ *Album, p *Photo) interface{} {
+		return p.Exif.Flash != nil && *p.Exif.Flash
+	}},
+	"exif.time": {tTime, false, func(_ *Album, p *Photo) interface{} {
+		t, _ := p.Exif.Time()
+		return t
+	}},
+}
+
+// ExprFields returns the names and types of the fields usable in
+// expressions, sorted by name.
+func ExprFields() []string {
+	names := make([]string, 0, len(exprFields))
+	for k, f := range exprFields {
+		names = append(names, k+" ("+f.typ.String()+")")
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseExpr parses and type checks a selection expression, such as
+//
+//	video and exif.make contains "canon" and year == 2013 and keywords contains 'kids'
+//
+// The operands are the fields listed by ExprFields, and literals:
+// numbers, true and false, "strings" with Go escapes, 'raw strings',
+// and dates (2013-05-01 in local time, or RFC3339).
+//
+// The operators, by increasing precedence:
+//
+//	or ||
+//	and &&
+//	not !
+//	== (or =) != < <= > >=   compare numbers, strings, times; == and != bools, too
+//	contains                 case-insensitive substring of a string, or element of a list
+//	=~ !~                    regexp (a string literal) match of a string, or any list element
+//
+// Unknown numbers and times (such as a missing exif.iso or taken) compare
+// false to anything, except with !=.
+func ParseExpr(s string) (*Expr, error) {
+	p := exprParser{lex: exprLexer{src: s}}
+	p.next()
+	root, err := p.or()
+	if err == nil {
+		err = p.err
+	}
+	if err == nil && p.tok.kind != tokEOF {
+		err = p.errorf(p.tok.pos, "unexpected %s", p.tok)
+	}
+	if err == nil && root.typ() != tBool {
+		err = p.errorf(root.pos(), "the expression is a %s, not a bool", root.typ())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Expr{src: s, root: root}, nil
+}
+
+// String returns the source of the expression.
+func (e *Expr) String() string { return e.src }
+
+// Match reports whether the photo of the album satisfies the expression.
+func (e *Expr) Match(a Album, p Photo) bool {
+	v, _ := e.root.eval(&a, &p)
+	return v == true
+}
+
+// MatchAlbum reports whether some photo of the album may satisfy the
+// expression. It is false only if the album's fields alone decide
+// against it, so the photos needn't even be fetched.
+func (e *Expr) MatchAlbum(a Album) bool {
+	v, known := e.root.eval(&a, nil)
+	return !known || v == true
+}
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokTime
+	tokOp
+)
+
+type token struct {
+	kind tokKind
+	pos  int
+	text string
+	val  interface{}
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+type exprLexer struct {
+	src string
+	pos int
+}
+
+var exprOps = []string{"==", "!=", "<=", ">=", "=~", "!~", "&&", "||", "<", ">", "=", "!", "(", ")"}
+
+func (l *exprLexer) next() (token, error) {
+	for l.pos < len(l.src) && (l.src[l.pos] == ' ' || l.src[l.pos] == '\t' || l.src[l.pos] == '\n' || l.src[l.pos] == '\r') {
+		l.pos++
+	}
+	start := l.pos
+	if start >= len(l.src) {
+		return token{kind: tokEOF, pos: start}, nil
+	}
+	c := l.src[start]
+	switch {
+	case c == '"' || c == '\'':
+		for l.pos++; l.pos < len(l.src) && l.src[l.pos] != c; l.pos++ {
+			if c == '"' && l.src[l.pos] == '\\' {
+				l.pos++
+			}
+		}
+		if l.pos >= len(l.src) {
+			return token{}, &ExprError{Pos: start, Msg: "unterminated string"}
+		}
+		l.pos++
+		text := l.src[start:l.pos]
+		if c == '\'' {
+			return token{kind: tokString, pos: start, text: text, val: text[1 : len(text)-1]}, nil
+		}
+		s, err := strconv.Unquote(text)
+		if err != nil {
+			return token{}, &ExprError{Pos: start, Msg: "bad string " + text}
+		}
+		return token{kind: tokString, pos: start, text: text, val: s}, nil
+
+	case c >= '0' && c <= '9' || c == '-' && l.pos+1 < len(l.src) && l.src[l.pos+1] >= '0' && l.src[l.pos+1] <= '9':
+		for l.pos++; l.pos < len(l.src) && strings.IndexByte("0123456789.:-+TZ", l.src[l.pos]) >= 0; l.pos++ {
+		}
+		text := l.src[start:l.pos]
+		if i := strings.IndexByte(text[1:], '-'); i >= 0 {
+			if t, err := time.ParseInLocation("2006-01-02", text, time.Local); err == nil {
+				return token{kind: tokTime, pos: start, text: text, val: t}, nil
+			}
+			if t, err := time.Parse(time.RFC3339, text); err == nil {
+				return token{kind: tokTime, pos: start, text: text, val: t}, nil
+			}
+			return token{}, &ExprError{Pos: start, Msg: "bad date " + text + " (use 2006-01-02 or RFC3339)"}
+		}
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return token{}, &ExprError{Pos: start, Msg: "bad number " + text}
+		}
+		return token{kind: tokNumber, pos: start, text: text, val: f}, nil
+
+	case c == '_' || c < utf8.RuneSelf && unicode.IsLetter(rune(c)):
+		for l.pos++; l.pos < len(l.src); l.pos++ {
+			c := l.src[l.pos]
+			if !(c == '_' || c == '.' || c >= '0' && c <= '9' || c < utf8.RuneSelf && unicode.IsLetter(rune(c))) {
+				break
+			}
+		}
+		text := l.src[start:l.pos]
+		switch lower := strings.ToLower(text); lower {
+		case "and", "or", "not", "contains":
+			return token{kind: tokOp, pos: start, text: lower}, nil
+		}
+		return token{kind: tokIdent, pos: start, text: text}, nil
+	}
+	for _, op := range exprOps {
+		if strings.HasPrefix(l.src[start:], op) {
+			l.pos += len(op)
+			return token{kind: tokOp, pos: start, text: op}, nil
+		}
+	}
+	r, _ := utf8.DecodeRuneInString(l.src[start:])
+	return token{}, &ExprError{Pos: start, Msg: fmt.Sprintf("unexpected character %q", r)}
+}
+
+type exprParser struct {
+	lex exprLexer
+	tok token
+	err error
+}
+
+func (p *exprParser) next() {
+	if p.err != nil {
+		return
+	}
+	if p.tok, p.err = p.lex.next(); p.err != nil {
+		p.tok = token{kind: tokEOF, pos: p.lex.pos}
+	}
+}
+
+func (p *exprParser) errorf(pos int, format string, args ...interface{}) error {
+	if p.err != nil {
+		return p.err
+	}
+	return &ExprError{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *exprParser) isOp(ops ...string) bool {
+	if p.tok.kind != tokOp {
+		return false
+	}
+	for _, op := range ops {
+		if p.tok.text == op {
+			return true
+		}
+	}
+	return false
+}
+
+// or = and { ("or" | "||") and }
+func (p *exprParser) or() (exprNode, error) {
+	return p.logical(p.and, "or", "||")
+}
+
+// and = not { ("and" | "&&") not }
+func (p *exprParser) and() (exprNode, error) {
+	return p.logical(p.not, "and", "&&")
+}
+
+func (p *exprParser) logical(operand func() (exprNode, error), ops ...string) (exprNode, error) {
+	x, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp(ops...) {
+		op := p.tok
+		p.next()
+		y, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range []exprNode{x, y} {
+			if n.typ() != tBool {
+				return nil, p.errorf(n.pos(), "%s needs bools, not a %s", op.text, n.typ())
+			}
+		}
+		x = &binaryNode{p: op.pos, op: ops[0], x: x, y: y}
+	}
+	return x, nil
+}
+
+// not = ("not" | "!") not | comparison
+func (p *exprParser) not() (exprNode, error) {
+	if !p.isOp("not", "!") {
+		return p.comparison()
+	}
+	op := p.tok
+	p.next()
+	x, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	if x.typ() != tBool {
+		return nil, p.errorf(x.pos(), "%s needs a bool, not a %s", op.text, x.typ())
+	}
+	return &notNode{p: op.pos, x: x}, nil
+}
+
+// comparison = operand [ op operand ]
+func (p *exprParser) comparison() (exprNode, error) {
+	x, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	if !p.isOp("==", "=", "!=", "<", "<=", ">", ">=", "contains", "=~", "!~") {
+		return x, nil
+	}
+	op := p.tok
+	if op.text == "=" {
+		op.text = "=="
+	}
+	p.next()
+	y, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	n := &binaryNode{p: op.pos, op: op.text, x: x, y: y}
+	switch op.text {
+	case "contains":
+		if x.typ() != tString && x.typ() != tList {
+			return nil, p.errorf(x.pos(), "contains needs a string or a list, not a %s", x.typ())
+		}
+		if y.typ() != tString {
+			return nil, p.errorf(y.pos(), "contains needs a string, not a %s", y.typ())
+		}
+	case "=~", "!~":
+		if x.typ() != tString && x.typ() != tList {
+			return nil, p.errorf(x.pos(), "%s needs a string or a list, not a %s", op.text, x.typ())
+		}
+		lit, ok := y.(*litNode)
+		if !ok || lit.t != tString {
+			return nil, p.errorf(y.pos(), "%s needs a string literal regexp", op.text)
+		}
+		if n.re, err = regexp.Compile(lit.v.(string)); err != nil {
+			return nil, p.errorf(y.pos(), "bad regexp: %v", err)
+		}
+	default:
+		if x.typ() != y.typ() {
+			return nil, p.errorf(op.pos, "cannot compare a %s to a %s", x.typ(), y.typ())
+		}
+		if x.typ() == tList || x.typ() == tBool && op.text != "==" && op.text != "!=" {
+			return nil, p.errorf(op.pos, "cannot use %s on a %s", op.text, x.typ())
+		}
+	}
+	return n, nil
+}
+
+// operand = field | literal | "(" or ")"
+func (p *exprParser) operand() (exprNode, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokIdent:
+		p.next()
+		switch strings.ToLower(tok.text) {
+		case "true":
+			return &litNode{p: tok.pos, t: tBool, v: true}, nil
+		case "false":
+			return &litNode{p: tok.pos, t: tBool, v: false}, nil
+		}
+		f, ok := exprFields[strings.ToLower(tok.text)]
+		if !ok {
+			return nil, p.errorf(tok.pos, "unknown field %s", tok.text)
+		}
+		return &fieldNode{p: tok.pos, f: f}, nil
+	case tokNumber, tokString, tokTime:
+		p.next()
+		t := map[tokKind]exprType{tokNumber: tNumber, tokString: tString, tokTime: tTime}[tok.kind]
+		return &litNode{p: tok.pos, t: t, v: tok.val}, nil
+	}
+	if p.isOp("(") {
+		p.next()
+		x, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.isOp(")") {
+			return nil, p.errorf(p.tok.pos, "expected \")\", found %s", p.tok)
+		}
+		p.next()
+		return x, nil
+	}
+	return nil, p.errorf(tok.pos, "expected a field or a value, found %s", tok)
+}
+
+// An exprNode is a node of the syntax tree. eval returns false as its
+// second result if the value is unknown, as it needs the missing Photo.
+type exprNode interface {
+	pos() int
+	typ() exprType
+	eval(*Album, *Photo) (interface{}, bool)
+}
+
+type litNode struct {
+	p int
+	t exprType
+	v interface{}
+}
+
+func (n *litNode) pos() int                                { return n.p }
+func (n *litNode) typ() exprType                           { return n.t }
+func (n *litNode) eval(*Album, *Photo) (interface{}, bool) { return n.v, true }
+
+type fieldNode struct {
+	p int
+	f exprField
+}
+
+func (n *fieldNode) pos() int      { return n.p }
+func (n *fieldNode) typ() exprType { return n.f.typ }
+func (n *fieldNode) eval(a *Album, p *Photo) (interface{}, bool) {
+	if p == nil && !n.f.album {
+		return nil, false
+	}
+	return n.f.get(a, p), true
+}
+
+type notNode struct {
+	p int
+	x exprNode
+}
+
+func (n *notNode) pos() int      { return n.p }
+func (n *notNode) typ() exprType { return tBool }
+func (n *notNode) eval(a *Album, p *Photo) (interface{}, bool) {
+	v, known := n.x.eval(a, p)
+	if !known {
+		return nil, false
+	}
+	return !v.(bool), true
+}
+
+type binaryNode struct {
+	p    int
+	op   string
+	x, y exprNode
+	re   *regexp.Regexp
+}
+
+func (n *binaryNode) pos() int      { return n.p }
+func (n *binaryNode) typ() exprType { return tBool }
+
+func (n *binaryNode) eval(a *Album, p *Photo) (interface{}, bool) {
+	x, xKnown := n.x.eval(a, p)
+	switch n.op {
+	case "and", "or":
+		// A known false (for and) or true (for or) side decides.
+		decisive := n.op == "or"
+		if xKnown && x.(bool) == decisive {
+			return decisive, true
+		}
+		y, yKnown := n.y.eval(a, p)
+		if yKnown && y.(bool) == decisive {
+			return decisive, true
+		}
+		if xKnown && yKnown {
+			return !decisive, true
+		}
+		return nil, false
+	}
+	if !xKnown {
+		return nil, false
+	}
+	y, yKnown := n.y.eval(a, p)
+	if !yKnown {
+		return nil, false
+	}
+	switch n.op {
+	case "contains":
+		sub := strings.ToLower(y.(string))
+		if s, ok := x.(string); ok {
+			return strings.Contains(strings.ToLower(s), sub), true
+		}
+		for _, s := range x.([]string) {
+			if strings.ToLower(s) == sub {
+				return true, true
+			}
+		}
+		return false, true
+	case "=~", "!~":
+		match := false
+		if s, ok := x.(string); ok {
+			match = n.re.MatchString(s)
+		} else {
+			for _, s := range x.([]string) {
+				if match = n.re.MatchString(s); match {
+					break
+				}
+			}
+		}
+		return match == (n.op == "=~"), true
+	}
+	var c int
+	switch x := x.(type) {
+	case bool:
+		if x != y.(bool) {
+			c = 1
+		}
+	case string:
+		c = strings.Compare(x, y.(string))
+	case time.Time:
+		y := y.(time.Time)
+		if x.IsZero() || y.IsZero() {
+			return n.op == "!=", true
+		}
+		if x.Before(y) {
+			c = -1
+		} else if x.After(y) {
+			c = 1
+		}
+	case float64:
+		y := y.(float64)
+		if math.IsNaN(x) || math.IsNaN(y) {
+			return n.op == "!=", true
+		}
+		if x < y {
+			c = -1
+		} else if x > y {
+			c = 1
+		}
+	}
+	switch n.op {
+	case "==":
+		return c == 0, true
+	case "!=":
+		return c != 0, true
+	case "<":
+		return c < 0, true
+	case "<=":
+		return c <= 0, true
+	case ">":
+		return c > 0, true
+	default: // ">="
+		return c >= 0, true
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package picago
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpr(t *testing.T) {
+	iso := 400
+	taken := time.Date(2013, 8, 20, 10, 0, 0, 0, time.UTC).UnixNano() / int64(time.Millisecond)
+	album := Album{ID: "1", Title: "Summer 2013", AlbumType: "InstantUpload", Published: time.Date(2013, 9, 1, 0, 0, 0, 0, time.UTC)}
+	video := Photo{
+		ID: "v", Filename: "MVI_0001.MOV", Keywords: []string{"Kids", "beach"},
+		Media: []MediaContent{{Medium: "image"}, {Medium: "video"}},
+		Exif:  Exif{Make: "Canon", Model: "Canon PowerShot S95", ISO: &iso, Timestamp: &taken},
+	}
+	photo := Photo{ID: "p", Filename: "IMG_0002.JPG", Exif: Exif{Make: "NIKON"}}
+
+	for src, want := range map[string][2]bool{
+		`video and exif.make contains "canon" and year == 2013 and keywords contains 'kids'`: {true, false},
+		`not video`:                                    {false, true},
+		`!video || exif.iso >= 400`:                    {true, true},
+		`exif.iso < 400`:                               {false, false},
+		`exif.iso != 400`:                              {false, true},
+		`filename =~ '(?i)\.mov$'`:                     {true, false},
+		`filename !~ "^IMG_"`:                          {true, false},
+		`keywords =~ '^bea'`:                           {true, false},
+		`taken >= 2013-08-01 and taken < 2013-09-01`:   {true, false},
+		`taken > 2013-08-20T09:00:00Z`:                 {true, false},
+		`taken < 2014-01-01 or exif.time < 2014-01-01`: {true, false},
+		`taken != 2014-01-01`:                          {true, true},
+		`album.title contains "summer" && (id = "p")`:  {false, true},
+		`album.type == "InstantUpload" and true`:       {true, true},
+		`exif.flash == false`:                          {true, true},
+	} {
+		e, err := ParseExpr(src)
+		if err != nil {
+			t.Errorf("%s: %v", src, err)
+			continue
+		}
+		if got := [2]bool{e.Match(album, video), e.Match(album, photo)}; got != want {
+			t.Errorf("%s: got %v, wanted %v", src, got, want)
+		}
+	}
+
+	for src, want := range map[string]bool{
+		`album.type == "Blogger"`:                      false,
+		`album.type == "Blogger" or video`:             true,
+		`album.published < 2013-01-01 and video`:       false,
+		`not (album.published < 2013-01-01) and video`: true,
+		`video`: true,
+	} {
+		e, err := ParseExpr(src)
+		if err != nil {
+			t.Errorf("%s: %v", src, err)
+			continue
+		}
+		if got := e.MatchAlbum(album); got != want {
+			t.Errorf("%s: got %t, wanted %t", src, got, want)
+		}
+	}
+
+	for src, pos := range map[string]int{
+		`video and`:              9,
+		`filename == 3`:          9,
+		`year == 2013 and color`: 17,
+		`exif.iso > "x"`:         9,
+		`keywords < "a"`:         9,
+		`video and "a`:           10,
+		`filename =~ '('`:        12,
+		`filename`:               0,
+		`(video`:                 6,
+		`video and id`:           10,
+		`taken > 2013-13-01`:     8,
+		`video # x`:              6,
+		`video video`:            6,
+	} {
+		_, err := ParseExpr(src)
+		ee, ok := err.(*ExprError)
+		if !ok {
+			t.Errorf("%s: got %v, wanted an ExprError", src, err)
+			continue
+		}
+		if ee.Pos != pos {
+			t.Errorf("%s: got %v, wanted position %d", src, err, pos+1)
+		}
+	}
+}
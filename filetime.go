@@ -0,0 +1,118 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package picago
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// A TimeSource names one of the timestamps of a photo or album.
+type TimeSource string
+
+const (
+	// TimeExif is the time the photo was taken, from its Exif.
+	// Albums have no such time.
+	TimeExif = TimeSource("exif")
+
+	// TimeTimestamp is the gphoto:timestamp, the date of the photo or
+	// album as known (or set by the user) on the server.
+	TimeTimestamp = TimeSource("timestamp")
+
+	// TimePublished is the time of publishing (uploading).
+	TimePublished = TimeSource("published")
+
+	// TimeUpdated is the time of the last modification on the server.
+	TimeUpdated = TimeSource("updated")
+)
+
+// DefaultTimeOrder is the usual priority of the timestamps.
+var DefaultTimeOrder = []TimeSource{TimeExif, TimeTimestamp, TimePublished}
+
+// ParseTimeOrder parses a comma-separated list of TimeSources,
+// such as "exif,timestamp,published".
+func ParseTimeOrder(s string) ([]TimeSource, error) {
+	var order []TimeSource
+	for _, f := range strings.Split(s, ",") {
+		switch ts := TimeSource(strings.ToLower(strings.TrimSpace(f))); ts {
+		case TimeExif, TimeTimestamp, TimePublished, TimeUpdated:
+			order = append(order, ts)
+		case "":
+		default:
+			return nil, fmt.Errorf("unknown time source %q (known: exif, timestamp, published, updated)", f)
+		}
+	}
+	return order, nil
+}
+
+// Time returns the first non-zero timestamp of the photo in the given
+// order, false if there is none.
+func (p Photo) Time(order []TimeSource) (time.Time, bool) {
+	for _, ts := range order {
+		var t time.Time
+		switch ts {
+		case TimeExif:
+			t, _ = p.Exif.Time()
+		case TimeTimestamp:
+			t = p.Timestamp
+		case TimePublished:
+			t = p.Published
+		case TimeUpdated:
+			t = p.Updated
+		}
+		if !t.IsZero() {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Time returns the first non-zero timestamp of the album in the given
+// order, false if there is none. TimeExif is skipped.
+func (a Album) Time(order []TimeSource) (time.Time, bool) {
+	for _, ts := range order {
+		var t time.Time
+		switch ts {
+		case TimeTimestamp:
+			t = a.Timestamp
+		case TimePublished:
+			t = a.Published
+		case TimeUpdated:
+			t = a.Updated
+		}
+		if !t.IsZero() {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// SetPhotoTime sets the access and modification times of the file at path
+// to the photo's time in the given order. It does nothing if the photo
+// has none of those times.
+func SetPhotoTime(path string, p Photo, order []TimeSource) error {
+	if t, ok := p.Time(order); ok {
+		return os.Chtimes(path, t, t)
+	}
+	return nil
+}
+
+// SetAlbumTime sets the times of the album's directory, like SetPhotoTime.
+// Call it after the files in the directory have been written, as that
+// changes the directory's modification time.
+func SetAlbumTime(dir string, a Album, order []TimeSource) error {
+	if t, ok := a.Time(order); ok {
+		return os.Chtimes(dir, t, t)
+	}
+	return nil
+}
+
+// taken returns the time the photo was taken, as well as known.
+func (p Photo) taken() time.Time {
+	t, _ := p.Time(DefaultTimeOrder)
+	return t
+}
@@ -0,0 +1,156 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package picago
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// A MediaKind selects photos, videos or both.
+type MediaKind string
+
+const (
+	MediaAll   = MediaKind("")
+	MediaImage = MediaKind("image")
+	MediaVideo = MediaKind("video")
+)
+
+// ParseMediaKind parses "image", "video" or "all" (or empty).
+func ParseMediaKind(s string) (MediaKind, error) {
+	switch k := MediaKind(strings.ToLower(s)); k {
+	case MediaAll, MediaImage, MediaVideo:
+		return k, nil
+	case "all":
+		return MediaAll, nil
+	}
+	return "", fmt.Errorf("unknown media kind %q (known: image, video, all)", s)
+}
+
+// A Filter selects albums, and photos of those albums.
+// The zero Filter selects everything.
+//
+// The album conditions need only the album list, so filtered out albums'
+// photo feeds needn't be fetched at all.
+type Filter struct {
+	// IncludeTitle, if not nil, must match the album's title;
+	// ExcludeTitle, if not nil, must not.
+	IncludeTitle, ExcludeTitle *regexp.Regexp
+
+	// IncludeIDs, if not empty, are the only albums selected;
+	// ExcludeIDs are never selected.
+	IncludeIDs, ExcludeIDs []string
+
+	// IncludeTypes, if not empty, are the only AlbumTypes selected;
+	// ExcludeTypes are never selected. The empty string stands for
+	// the ordinary albums.
+	IncludeTypes, ExcludeTypes []string
+
+	// Rights, if not empty, are the only Rights selected.
+	Rights []string
+
+	// PublishedAfter, PublishedBefore, UpdatedAfter and UpdatedBefore,
+	// if not zero, limit the albums' Published and Updated times;
+	// After is inclusive, Before is exclusive.
+	PublishedAfter, PublishedBefore time.Time
+	UpdatedAfter, UpdatedBefore     time.Time
+
+	// Media selects the photos by kind.
+	Media MediaKind
+
+	// Where, if not nil, must match the photos. Albums are skipped
+	// if their fields alone decide against it (see Expr.MatchAlbum).
+	Where *Expr
+}
+
+// Album reports whether the album is selected.
+func (f Filter) Album(a Album) bool {
+	if f.IncludeTitle != nil && !f.IncludeTitle.MatchString(a.Title) ||
+		f.ExcludeTitle != nil && f.ExcludeTitle.MatchString(a.Title) {
+		return false
+	}
+	if len(f.IncludeIDs) != 0 && !contains(f.IncludeIDs, a.ID, false) ||
+		contains(f.ExcludeIDs, a.ID, false) {
+		return false
+	}
+	if len(f.IncludeTypes) != 0 && !contains(f.IncludeTypes, a.AlbumType, true) ||
+		contains(f.ExcludeTypes, a.AlbumType, true) {
+		return false
+	}
+	if len(f.Rights) != 0 && !contains(f.Rights, a.Rights, true) {
+		return false
+	}
+	return inRange(a.Published, f.PublishedAfter, f.PublishedBefore) &&
+		inRange(a.Updated, f.UpdatedAfter, f.UpdatedBefore) &&
+		(f.Where == nil || f.Where.MatchAlbum(a))
+}
+
+// Photo reports whether the photo of the album is selected.
+func (f Filter) Photo(a Album, p Photo) bool {
+	switch f.Media {
+	case MediaImage:
+		if p.IsVideo() {
+			return false
+		}
+	case MediaVideo:
+		if !p.IsVideo() {
+			return false
+		}
+	}
+	return f.Where == nil || f.Where.Match(a, p)
+}
+
+// PhotoKey returns a string which differs for Filters selecting the photos
+// of an album differently, and is empty if all of them are selected.
+func (f Filter) PhotoKey() string {
+	var key string
+	if f.Media != MediaAll {
+		key = "media=" + string(f.Media)
+	}
+	if f.Where != nil {
+		if key != "" {
+			key += " "
+		}
+		key += "where=" + f.Where.String()
+	}
+	return key
+}
+
+// Albums returns the selected albums.
+func (f Filter) Albums(albums []Album) []Album {
+	var selected []Album
+	for _, a := range albums {
+		if f.Album(a) {
+			selected = append(selected, a)
+		}
+	}
+	return selected
+}
+
+// Photos returns the selected photos of the album.
+func (f Filter) Photos(a Album, photos []Photo) []Photo {
+	var selected []Photo
+	for _, p := range photos {
+		if f.Photo(a, p) {
+			selected = append(selected, p)
+		}
+	}
+	return selected
+}
+
+func contains(list []string, s string, fold bool) bool {
+	for _, x := range list {
+		if x == s || fold && strings.EqualFold(x, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func inRange(t, after, before time.Time) bool {
+	return (after.IsZero() || !t.Before(after)) && (before.IsZero() || t.Before(before))
+}
@@ -0,0 +1,98 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package picago
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestFilter(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2014, 7, d, 0, 0, 0, 0, time.UTC) }
+	albums := []Album{
+		{ID: "1", Title: "Summer", Rights: "public", Published: day(1), Updated: day(2)},
+		{ID: "2", Title: "Summer blog", AlbumType: "Blogger", Rights: "public", Published: day(3), Updated: day(3)},
+		{ID: "3", Title: "Profile Photos", AlbumType: "ProfilePhotos", Rights: "private", Published: day(5), Updated: day(9)},
+		{ID: "4", Title: "Winter", Rights: "private", Published: day(10), Updated: day(10)},
+	}
+	for i, tc := range []struct {
+		f    Filter
+		want string
+	}{
+		{Filter{}, "1234"},
+		{Filter{IncludeTitle: regexp.MustCompile("^Summer")}, "12"},
+		{Filter{ExcludeTitle: regexp.MustCompile("(?i)photos")}, "124"},
+		{Filter{IncludeIDs: []string{"2", "4"}, ExcludeIDs: []string{"4"}}, "2"},
+		{Filter{ExcludeTypes: []string{"blogger", "ProfilePhotos"}}, "14"},
+		{Filter{IncludeTypes: []string{""}}, "14"},
+		{Filter{Rights: []string{"private"}}, "34"},
+		{Filter{PublishedAfter: day(3), PublishedBefore: day(10)}, "23"},
+		{Filter{UpdatedAfter: day(9)}, "34"},
+	} {
+		var got string
+		for _, a := range tc.f.Albums(albums) {
+			got += a.ID
+		}
+		if got != tc.want {
+			t.Errorf("%d. got %q, wanted %q", i, got, tc.want)
+		}
+	}
+
+	photos := []Photo{
+		{ID: "p", Media: []MediaContent{{Medium: "image"}}},
+		{ID: "v", Media: []MediaContent{{Medium: "image"}, {Medium: "video"}}},
+	}
+	for kind, want := range map[MediaKind]string{MediaAll: "pv", MediaImage: "p", MediaVideo: "v"} {
+		var got string
+		for _, p := range (Filter{Media: kind}).Photos(Album{}, photos) {
+			got += p.ID
+		}
+		if got != want {
+			t.Errorf("%q: got %q, wanted %q", kind, got, want)
+		}
+	}
+}
+
+func TestMirrorFilterWidened(t *testing.T) {
+	fake := &fakePicasa{photos: make(map[string][]Photo), content: make(map[string]string)}
+	fake.albums = []Album{{ID: "1", Name: "Summer", Title: "Summer", ETag: "a1"}}
+	fake.photos["1"] = []Photo{fake.photo("1", "11", "a.jpg", "p1", "aaa"), fake.photo("1", "12", "b.jpg", "p1", "bbb")}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "picago-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	where, err := ParseExpr(`filename == "a.jpg"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := Mirror{
+		Client: &Client{Client: &http.Client{Transport: rewriteTransport{host: srv.Listener.Addr().String()}}},
+		Dir:    dir,
+		Filter: &Filter{Where: where},
+	}
+	if stats, err := m.Sync(context.Background()); err != nil || stats.Added != 1 {
+		t.Fatalf("got %+v, %v", stats, err)
+	}
+
+	// Without the filter, the album is not complete anymore.
+	m.Filter = nil
+	if stats, err := m.Sync(context.Background()); err != nil || stats.Added != 1 || stats.Unchanged != 1 {
+		t.Fatalf("got %+v, %v", stats, err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, AlbumDirName(fake.albums[0]), "b.jpg")); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package picago
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A GeoPoint is a WGS84 position, in degrees.
+type GeoPoint struct {
+	Latitude, Longitude float64
+}
+
+// A GeoBox is a bounding box given by its lower (south-west) and
+// upper (north-east) corners.
+type GeoBox struct {
+	Lower, Upper GeoPoint
+}
+
+func (p GeoPoint) String() string {
+	return strconv.FormatFloat(p.Latitude, 'f', -1, 64) + " " +
+		strconv.FormatFloat(p.Longitude, 'f', -1, 64)
+}
+
+// Valid reports whether the latitude is in [-90, 90] and the longitude
+// is in [-180, 180].
+func (p GeoPoint) Valid() bool {
+	return -90 <= p.Latitude && p.Latitude <= 90 &&
+		-180 <= p.Longitude && p.Longitude <= 180
+}
+
+// ParseGeoPoint parses a gml:pos ("latitude longitude", separated by any
+// whitespace).
+//
+// It returns nil for an empty string and for "0 0", which Picasa uses
+// for "no location".
+func ParseGeoPoint(s string) (*GeoPoint, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("point=%q but couldn't parse it as lat/long", s)
+	}
+	var p GeoPoint
+	var err error
+	if p.Latitude, err = strconv.ParseFloat(fields[0], 64); err != nil {
+		return nil, fmt.Errorf("cannot parse %q as latitude: %v", fields[0], err)
+	}
+	if p.Longitude, err = strconv.ParseFloat(fields[1], 64); err != nil {
+		return nil, fmt.Errorf("cannot parse %q as longitude: %v", fields[1], err)
+	}
+	if p.Latitude == 0 && p.Longitude == 0 {
+		return nil, nil
+	}
+	if !p.Valid() {
+		return nil, fmt.Errorf("point=%q is out of range", s)
+	}
+	return &p, nil
+}
+
+// ParseGeoBox parses the corners of a gml:Envelope, checking that they
+// are in range, and the lower corner is south-west of the upper one.
+// It returns nil if both corners are empty.
+func ParseGeoBox(lower, upper string) (*GeoBox, error) {
+	if strings.TrimSpace(lower) == "" && strings.TrimSpace(upper) == "" {
+		return nil, nil
+	}
+	lo, err := ParseGeoPoint(lower)
+	if err != nil {
+		return nil, fmt.Errorf("lowerCorner: %v", err)
+	}
+	up, err := ParseGeoPoint(upper)
+	if err != nil {
+		return nil, fmt.Errorf("upperCorner: %v", err)
+	}
+	var b GeoBox
+	if lo != nil {
+		b.Lower = *lo
+	}
+	if up != nil {
+		b.Upper = *up
+	}
+	if b.Lower.Latitude > b.Upper.Latitude {
+		return nil, fmt.Errorf("envelope %q - %q: lower corner is north of the upper corner", lower, upper)
+	}
+	// The lower corner is east of the upper one only if the box
+	// crosses the antimeridian.
+	if b.Lower.Longitude > b.Upper.Longitude && !(b.Lower.Longitude > 0 && b.Upper.Longitude < 0) {
+		return nil, fmt.Errorf("envelope %q - %q: lower corner is east of the upper corner", lower, upper)
+	}
+	return &b, nil
+}
+
+// geo returns the georss:where location of the entry.
+func (e *Entry) geo() (*GeoPoint, *GeoBox, error) {
+	p, err := ParseGeoPoint(e.Point)
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := ParseGeoBox(e.LowerCorner, e.UpperCorner)
+	if err != nil {
+		return nil, nil, err
+	}
+	return p, b, nil
+}
@@ -63,9 +63,20 @@ type Album struct {
 	// Location is free-form location text. e.g. "San Bruno Mountain"
 	Location string
 
+	// Point is the geotagged position of the album, nil if unknown
+	// or invalid.
+	Point *GeoPoint
+
+	// Box is the bounding box of the album's photos, nil if unknown
+	// or invalid.
+	Box *GeoBox
+
 	// URL is the main human-oriented (HTML) URL to the album.
 	URL string
 
+	// ETag changes whenever the album's entry changes.
+	ETag string
+
 	// Published is the either the time the user actually created
 	// and published the gallery or (in the case of Picasaweb at
 	// least), the date that the user set on the gallery.  It will
@@ -81,6 +92,37 @@ type Album struct {
 	Updated time.Time
 
 	AuthorName, AuthorURI string
+
+	// NumPhotos is the number of photos and videos in the album,
+	// NumPhotosRemaining is how many more can be uploaded into it.
+	NumPhotos, NumPhotosRemaining int
+
+	// BytesUsed is the storage the album's contents use, in bytes.
+	BytesUsed int64
+
+	// Access is the visibility of the album.
+	// e.g. "public", "protected", "private" or "only_you"
+	Access string
+
+	// Timestamp is the date of the album as set by the user
+	// (gphoto:timestamp), which can differ from Published.
+	Timestamp time.Time
+
+	CommentingEnabled bool
+
+	// AllowDownloads and AllowPrints are true unless the owner
+	// disabled them; missing elements mean the default (allowed).
+	AllowDownloads, AllowPrints bool
+
+	// Cover is the album's cover thumbnail. Its URL is empty if the
+	// feed has no cover.
+	Cover Thumbnail
+}
+
+// A Thumbnail is a scaled-down variant of an album cover or a photo.
+type Thumbnail struct {
+	URL           string
+	Width, Height int
 }
 
 // A Photo is a photo (or video) in a Picasaweb (or G+) gallery.
@@ -89,6 +131,8 @@ type Photo struct {
 	ID string
 
 	// Filename is the image's filename from the Atom title field.
+	// It can contain any character (even slashes), so use SanitiseName
+	// or PhotoFileNames to get a local file name.
 	Filename string
 
 	// Description is the caption of the photo.
@@ -98,14 +142,21 @@ type Photo struct {
 	Published, Updated time.Time
 
 	// Latitude and Longitude optionally contain the GPS coordinates
-	// of the photo.
+	// of the photo. They are the same as Point, zero if it is nil.
 	Latitude, Longitude float64
 
+	// Point is the position of the photo, nil if unknown.
+	Point *GeoPoint
+
+	// Box is the bounding box of the photo's location, nil if unknown.
+	Box *GeoBox
+
 	// Location is free-form text describing the location of the
 	// photo.
 	Location string
 
-	// URL is the URL of the photo or video.
+	// URL is the URL of the photo or video, as chosen by
+	// DefaultMediaSelector from Media.
 	URL string
 
 	// PageURL is the URL to the page showing just this image.
@@ -118,53 +169,112 @@ type Photo struct {
 	// It is zero if unknown.
 	Position int
 
-	Exif *Exif
+	// Exif contains the camera's metadata. It is the zero Exif if the
+	// feed has none.
+	Exif Exif
 
 	Width, Height int
+
+	// AlbumID is the ID of the album containing the photo, useful
+	// when the photo comes from a user-wide feed.
+	AlbumID string
+
+	// Thumbnails are the scaled-down variants of the photo, in the
+	// order of the feed (usually ascending size).
+	Thumbnails []Thumbnail
+
+	// Checksum is the client-provided checksum, often empty.
+	Checksum string
+
+	// Size is the size of the original in bytes.
+	Size int64
+
+	// Rotation is the rotation of the photo in degrees (0, 90, 180
+	// or 270) to apply for display.
+	Rotation int
+
+	CommentCount int
+
+	// Version is incremented on every modification of the photo.
+	Version int64
+
+	// Timestamp is the time the photo was taken, as known by the
+	// server (gphoto:timestamp).
+	Timestamp time.Time
+
+	// Media contains all the available variants of the photo or
+	// video; use Select to choose one.
+	Media []MediaContent
+
+	// ETag changes whenever the photo's entry changes.
+	ETag string
 }
 
 // GetAlbums returns the list of albums of the given userID.
 // If userID is empty, "default" is used.
 func GetAlbums(client *http.Client, userID string) ([]Album, error) {
-	if userID == "" {
-		userID = "default"
-	}
-	url := strings.Replace(albumURL, "{userID}", userID, 1)
+	return (&Client{Client: client}).GetAlbums(userID)
+}
 
+// GetAlbums returns the list of albums of the given userID.
+// If userID is empty, "default" is used.
+func (c *Client) GetAlbums(userID string) ([]Album, error) {
 	var albums []Album
-	var err error
-	hasMore, startIndex := true, 1
-	for hasMore {
-		albums, hasMore, err = getAlbums(albums, client, url, startIndex)
-		if !hasMore {
-			break
-		}
-		startIndex = len(albums) + 1
-	}
+	err := c.WalkAlbums(userID, func(a Album) error {
+		albums = append(albums, a)
+		return nil
+	})
 	return albums, err
 }
 
-func getAlbums(albums []Album, client *http.Client, url string, startIndex int) ([]Album, bool, error) {
-	if startIndex <= 0 {
-		startIndex = 1
-	}
-	feed, err := downloadAndParse(client,
-		strings.Replace(url, "{startIndex}", strconv.Itoa(startIndex), 1))
-	if err != nil {
-		return albums, false, err
-	}
-	if len(feed.Entries) == 0 {
-		return albums, false, nil
+// WalkAlbums calls f for each album of the given userID, as soon as it is
+// read from the feed. It stops at the first error returned by f.
+// If userID is empty, "default" is used.
+func WalkAlbums(client *http.Client, userID string, f func(Album) error) error {
+	return (&Client{Client: client}).WalkAlbums(userID, f)
+}
+
+// WalkAlbums calls f for each album of the given userID, as soon as it is
+// read from the feed. It stops at the first error returned by f.
+// If userID is empty, "default" is used.
+func (c *Client) WalkAlbums(userID string, f func(Album) error) error {
+	if userID == "" {
+		userID = "default"
 	}
-	for _, entry := range feed.Entries {
-		albums = append(albums, entry.album())
+	url := strings.Replace(albumURL, "{userID}", userID, 1)
+	return c.walkFeed(url, func(e *Entry, _ int) error {
+		return f(e.album())
+	})
+}
+
+// walkFeed pages through the feed at url (with a {startIndex} placeholder),
+// calling f with each entry and its 1-based position.
+//
+// The number of entries can change while the walk is happening. More
+// realistically, Aaron Boodman has observed feed.NumPhotos disagreeing with
+// len(feed.Entries). So to be on the safe side, just keep trying until we
+// get a response with zero entries.
+func (c *Client) walkFeed(url string, f func(*Entry, int) error) error {
+	startIndex := 1
+	for {
+		position := startIndex
+		n, err := c.downloadAndStream(
+			strings.Replace(url, "{startIndex}", strconv.Itoa(startIndex), 1),
+			func(e *Entry) error {
+				position++
+				return f(e, position-1)
+			})
+		if err != nil || n == 0 {
+			return err
+		}
+		startIndex += n
 	}
-	return albums, true, nil
 }
 
 func (e *Entry) album() Album {
 	a := Album{
 		ID:          e.ID,
+		ETag:        e.ETag,
 		Name:        e.Name,
 		Title:       e.Title,
 		Rights:      e.Rights,
@@ -175,6 +285,19 @@ func (e *Entry) album() Album {
 		Published:   e.Published,
 		Updated:     e.Updated,
 		Description: e.Summary,
+
+		NumPhotos:          e.NumPhotos,
+		NumPhotosRemaining: e.NumPhotosRemaining,
+		BytesUsed:          e.BytesUsed,
+		Access:             e.Access,
+		Timestamp:          msecTime(e.Timestamp),
+		CommentingEnabled:  e.CommentingEnabled,
+		AllowDownloads:     e.AllowDownloads == nil || *e.AllowDownloads,
+		AllowPrints:        e.AllowPrints == nil || *e.AllowPrints,
+	}
+	// A broken location shouldn't make the whole album unusable.
+	if point, box, err := e.geo(); err == nil {
+		a.Point, a.Box = point, box
 	}
 	for _, link := range e.Links {
 		if link.Rel == "alternate" && link.Type == "text/html" {
@@ -186,90 +309,103 @@ func (e *Entry) album() Album {
 		if a.Description == "" {
 			a.Description = e.Media.Description
 		}
+		if len(e.Media.Thumbnail) != 0 {
+			a.Cover = e.Media.Thumbnail[0].thumbnail()
+		}
 	}
 	return a
 }
 
-func GetPhotos(client *http.Client, userID, albumID string) ([]Photo, error) {
-	if userID == "" {
-		userID = "default"
-	}
-	url := strings.Replace(photoURL, "{userID}", userID, 1)
-	url = strings.Replace(url, "{albumID}", albumID, 1)
+func (mc MediaContent) thumbnail() Thumbnail {
+	return Thumbnail{URL: mc.URL, Width: mc.Width, Height: mc.Height}
+}
 
-	var photos []Photo
-	var err error
-	hasMore, startIndex := true, 1
-	for hasMore {
-		photos, hasMore, err = getPhotos(photos, client, url, startIndex)
-		if !hasMore {
-			break
-		}
-		startIndex = len(photos) + 1
+// msecTime converts the milliseconds since the Unix epoch, as used by
+// gphoto:timestamp and exif:time, to time.Time. Zero means unknown.
+func msecTime(msec int64) time.Time {
+	if msec == 0 {
+		return time.Time{}
 	}
-	return photos, err
+	return time.Unix(msec/1000, (msec%1000)*int64(time.Millisecond)).UTC()
 }
 
-func getPhotos(photos []Photo, client *http.Client, url string, startIndex int) ([]Photo, bool, error) {
-	if startIndex <= 0 {
-		startIndex = 1
-	}
-	feed, err := downloadAndParse(client,
-		strings.Replace(url, "{startIndex}", strconv.Itoa(startIndex), 1))
+// GetPhotos returns the photos of the given album.
+// If userID is empty, "default" is used.
+func GetPhotos(client *http.Client, userID, albumID string) ([]Photo, error) {
+	return (&Client{Client: client}).GetPhotos(userID, albumID)
+}
+
+// GetPhotos returns the photos of the given album.
+// If userID is empty, "default" is used.
+func (c *Client) GetPhotos(userID, albumID string) ([]Photo, error) {
+	var photos []Photo
+	err := c.WalkPhotos(userID, albumID, func(p Photo) error {
+		photos = append(photos, p)
+		return nil
+	})
 	if err != nil {
-		return nil, false, err
+		return nil, err
 	}
-	if len(feed.Entries) == 0 {
-		return photos, false, nil
+	return photos, nil
+}
+
+// WalkPhotos calls f for each photo of the given album, as soon as it is
+// read from the feed. It stops at the first error returned by f.
+// If userID is empty, "default" is used.
+func WalkPhotos(client *http.Client, userID, albumID string, f func(Photo) error) error {
+	return (&Client{Client: client}).WalkPhotos(userID, albumID, f)
+}
+
+// WalkPhotos calls f for each photo of the given album, as soon as it is
+// read from the feed. It stops at the first error returned by f.
+// If userID is empty, "default" is used.
+func (c *Client) WalkPhotos(userID, albumID string, f func(Photo) error) error {
+	if userID == "" {
+		userID = "default"
 	}
-	for i, entry := range feed.Entries {
-		p, err := entry.photo()
+	url := strings.Replace(photoURL, "{userID}", userID, 1)
+	url = strings.Replace(url, "{albumID}", albumID, 1)
+	return c.walkFeed(url, func(e *Entry, position int) error {
+		p, err := e.photo()
 		if err != nil {
-			return nil, false, err
+			return err
 		}
-		p.Position = startIndex + i
-		photos = append(photos, p)
-	}
-
-	// The number of photos can change while the import is happening. More
-	// realistically, Aaron Boodman has observed feed.NumPhotos disagreeing with
-	// len(feed.Entries). So to be on the safe side, just keep trying until we
-	// get a response with zero entries.
-	return photos, true, nil
+		p.Position = position
+		return f(p)
+	})
 }
 
 func (e *Entry) photo() (p Photo, err error) {
-	var lat, long float64
-	if e.Point != "0.0 0.0" { // ignore special case
-		i := strings.Index(e.Point, " ")
-		if i >= 1 {
-			lat, err = strconv.ParseFloat(e.Point[:i], 64)
-			if err != nil {
-				return p, fmt.Errorf("cannot parse %q as latitude: %v", e.Point[:i], err)
-			}
-			long, err = strconv.ParseFloat(e.Point[i+1:], 64)
-			if err != nil {
-				return p, fmt.Errorf("cannot parse %q as longitude: %v", e.Point[i+1:], err)
-			}
-		}
-		if e.Point != "" && lat == 0 && long == 0 {
-			return p, fmt.Errorf("point=%q but couldn't parse it as lat/long", e.Point)
-		}
+	// A broken location shouldn't make the whole photo unusable.
+	point, box, err := e.geo()
+	if err != nil {
+		point, box = nil, nil
 	}
 	p = Photo{
 		ID:          e.ID,
-		Exif:        e.Exif,
+		ETag:        e.ETag,
 		Description: e.Summary,
 		Filename:    e.Title,
 		Location:    e.Location,
 		Published:   e.Published,
 		Updated:     e.Updated,
-		Latitude:    lat,
-		Longitude:   long,
+		Point:       point,
+		Box:         box,
+
+		AlbumID:      e.AlbumID,
+		Checksum:     e.Checksum,
+		Size:         e.Size,
+		Rotation:     e.Rotation,
+		CommentCount: e.CommentCount,
+		Version:      e.Version,
+		Timestamp:    msecTime(e.Timestamp),
+	}
+	if point != nil {
+		p.Latitude, p.Longitude = point.Latitude, point.Longitude
+	}
+	if e.Exif != nil {
+		p.Exif = *e.Exif
 	}
-	// Sanitise Filename in case of slashes in filename (sometimes present in google photos)
-	p.Filename = strings.Split(p.Filename, "/")[len(strings.Split(p.Filename, "/"))-1]
-
 	for _, link := range e.Links {
 		if link.Rel == "alternate" && link.Type == "text/html" {
 			p.PageURL = link.URL
@@ -285,12 +421,16 @@ func (e *Entry) photo() (p Photo, err error) {
 		if p.Description == "" {
 			p.Description = e.Media.Description
 		}
-		if mc, ok := e.Media.bestContent(); ok {
+		p.Media = e.Media.Content
+		if mc, ok := p.Select(nil); ok {
 			p.URL, p.Type, p.Width, p.Height = mc.URL, mc.Type, mc.Width, mc.Height
 		}
 		if p.Filename == "" {
 			p.Filename = e.Media.Title
 		}
+		for _, mc := range e.Media.Thumbnail {
+			p.Thumbnails = append(p.Thumbnails, mc.thumbnail())
+		}
 	}
 	return p, nil
 }
@@ -299,55 +439,88 @@ func (e *Entry) DecodeReader(r io.Reader) error {
 	return xml.NewDecoder(r).Decode(e)
 }
 
-func (m *Media) bestContent() (ret MediaContent, ok bool) {
-	// Find largest non-Flash video.
-	var bestPixels int64
-	for _, mc := range m.Content {
-		thisPixels := int64(mc.Width) * int64(mc.Height)
-		if mc.Medium == "video" && mc.Type != "application/x-shockwave-flash" && thisPixels > bestPixels {
-			ret = mc
-			ok = true
-			bestPixels = thisPixels
-		}
+func (c *Client) downloadAndParse(url string) (*Atom, error) {
+	body, err := c.openFeed(url)
+	if err != nil {
+		return nil, err
 	}
-	if ok {
-		return
+	defer body.Close()
+	if c.Format == FormatJSON {
+		return ParseJSON(body)
 	}
+	return ParseAtom(body)
+}
 
-	// Else, just find largest anything.
-	bestPixels = 0
-	for _, mc := range m.Content {
-		thisPixels := int64(mc.Width) * int64(mc.Height)
-		if thisPixels > bestPixels {
-			ret = mc
-			ok = true
-			bestPixels = thisPixels
+// downloadAndStream calls f with each entry of the feed at url,
+// and returns the number of entries.
+func (c *Client) downloadAndStream(url string, f func(*Entry) error) (int, error) {
+	body, err := c.openFeed(url)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	fd := NewFeedDecoder(body)
+	if c.Format == FormatJSON {
+		fd = NewJSONFeedDecoder(body)
+	}
+	var n int
+	for {
+		e, err := fd.Next()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, fmt.Errorf("downloadAndStream(%s): %v", url, err)
 		}
+		if err = f(e); err != nil {
+			return n, err
+		}
+		n++
 	}
-	return
 }
 
-func downloadAndParse(client *http.Client, url string) (*Atom, error) {
-	resp, err := client.Get(url)
+// openFeed GETs the url in the Client's Format and returns the response
+// body, teed into DebugDir if it is set.
+func (c *Client) openFeed(url string) (io.ReadCloser, error) {
+	ext := ".xml"
+	if c.Format == FormatJSON {
+		url += "&alt=json"
+		ext = ".json"
+	}
+	resp, err := c.Get(url)
 	if err != nil {
 		return nil, fmt.Errorf("downloadAndParse: get %q: %v", url, err)
 	}
-	defer resp.Body.Close()
 	if resp.StatusCode >= http.StatusBadRequest {
 		buf, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
 		return nil, fmt.Errorf("downloadAndParse(%s) got %s (%s)", url, resp.Status, buf)
 	}
-	var r io.Reader = resp.Body
-	if DebugDir != "" {
-		fn := filepath.Join(DebugDir, neturl.QueryEscape(url)+".xml")
-		xmlfh, err := os.Create(fn)
-		if err != nil {
-			return nil, fmt.Errorf("error creating debug filx %s: %v", fn, err)
+	if DebugDir == "" {
+		return resp.Body, nil
+	}
+	fn := filepath.Join(DebugDir, neturl.QueryEscape(url)+ext)
+	xmlfh, err := os.Create(fn)
+	if err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("error creating debug filx %s: %v", fn, err)
+	}
+	return teeReadCloser{Reader: io.TeeReader(resp.Body, xmlfh), closers: []io.Closer{resp.Body, xmlfh}}, nil
+}
+
+type teeReadCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (t teeReadCloser) Close() error {
+	var firstErr error
+	for _, c := range t.closers {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
 		}
-		defer xmlfh.Close()
-		r = io.TeeReader(resp.Body, xmlfh)
 	}
-	return ParseAtom(r)
+	return firstErr
 }
 
 // DownloadPhoto returns an io.ReadCloser for reading the photo bytes
@@ -365,11 +538,16 @@ func DownloadPhoto(client *http.Client, url string) (io.ReadCloser, error) {
 
 // GetUser returns the user's info
 func GetUser(client *http.Client, userID string) (User, error) {
+	return (&Client{Client: client}).GetUser(userID)
+}
+
+// GetUser returns the user's info
+func (c *Client) GetUser(userID string) (User, error) {
 	if userID == "" {
 		userID = "default"
 	}
 	url := strings.Replace(userURL, "{userID}", userID, 1)
-	feed, err := downloadAndParse(client, url)
+	feed, err := c.downloadAndParse(url)
 	if err != nil {
 		return User{}, fmt.Errorf("GetUser: downloading %s: %v", url, err)
 	}
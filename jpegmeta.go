@@ -0,0 +1,437 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package picago
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"unicode/utf8"
+)
+
+// ErrNotJPEG is returned by EmbedJPEGMetadata for non-JPEG data.
+var ErrNotJPEG = errors.New("not a JPEG")
+
+const (
+	markerSOI   = 0xD8
+	markerEOI   = 0xD9
+	markerSOS   = 0xDA
+	markerAPP0  = 0xE0
+	markerAPP1  = 0xE1
+	markerAPP13 = 0xED
+)
+
+var (
+	exifHeader      = []byte("Exif\x00\x00")
+	photoshopHeader = []byte("Photoshop 3.0\x00")
+)
+
+// EmbedJPEGMetadata returns the JPEG with the photo's Description as EXIF
+// ImageDescription, its Point as EXIF GPS and its Description and
+// Keywords as IPTC caption and keywords. The image data is not re-encoded,
+// and the existing EXIF and IPTC data is kept, except the overwritten
+// fields. The JPEG is returned as is if the photo has none of these.
+func EmbedJPEGMetadata(jpeg []byte, p Photo) ([]byte, error) {
+	if len(jpeg) < 4 || jpeg[0] != 0xFF || jpeg[1] != markerSOI {
+		return nil, ErrNotJPEG
+	}
+	point := p.Point
+	if point != nil && !point.Valid() {
+		point = nil
+	}
+	if p.Description == "" && len(p.Keywords) == 0 && point == nil {
+		return jpeg, nil
+	}
+	segs, rest, err := jpegSegments(jpeg)
+	if err != nil {
+		return nil, err
+	}
+
+	exifIdx, iptcIdx, insertAt := -1, -1, 0
+	for i, s := range segs {
+		switch {
+		case s.marker == markerAPP1 && bytes.HasPrefix(s.data, exifHeader) && exifIdx < 0:
+			exifIdx = i
+		case s.marker == markerAPP13 && bytes.HasPrefix(s.data, photoshopHeader) && iptcIdx < 0:
+			iptcIdx = i
+		}
+		if insertAt == i && (s.marker == markerAPP0 || s.marker == markerAPP1) {
+			insertAt = i + 1
+		}
+	}
+
+	if p.Description != "" || point != nil {
+		var tiff []byte
+		if exifIdx >= 0 {
+			tiff = segs[exifIdx].data[len(exifHeader):]
+		}
+		if tiff, err = embedTIFF(tiff, p.Description, point); err != nil {
+			return nil, err
+		}
+		s := jpegSegment{marker: markerAPP1, data: append(append([]byte(nil), exifHeader...), tiff...)}
+		if exifIdx >= 0 {
+			segs[exifIdx] = s
+		} else {
+			segs = insertSegment(segs, insertAt, s)
+			if iptcIdx >= insertAt {
+				iptcIdx++
+			}
+			insertAt++
+		}
+	}
+	if p.Description != "" || len(p.Keywords) != 0 {
+		var irb []byte
+		if iptcIdx >= 0 {
+			irb = segs[iptcIdx].data[len(photoshopHeader):]
+		}
+		s := jpegSegment{marker: markerAPP13, data: append(append([]byte(nil), photoshopHeader...),
+			embedIRB(irb, p.Description, p.Keywords)...)}
+		if iptcIdx >= 0 {
+			segs[iptcIdx] = s
+		} else {
+			segs = insertSegment(segs, insertAt, s)
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(len(jpeg) + 1024)
+	buf.Write([]byte{0xFF, markerSOI})
+	for _, s := range segs {
+		if len(s.data)+2 > math.MaxUint16 {
+			return nil, fmt.Errorf("JPEG segment %02X too long (%d bytes)", s.marker, len(s.data))
+		}
+		buf.Write([]byte{0xFF, s.marker, byte((len(s.data) + 2) >> 8), byte(len(s.data) + 2)})
+		buf.Write(s.data)
+	}
+	buf.Write(rest)
+	return buf.Bytes(), nil
+}
+
+// EmbedJPEGMetadataFile writes the JPEG at src into dst with the metadata
+// of the photo embedded (see EmbedJPEGMetadata). src and dst can be the
+// same; dst is replaced atomically.
+func EmbedJPEGMetadataFile(dst, src string, p Photo) error {
+	b, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if b, err = EmbedJPEGMetadata(b, p); err != nil {
+		return fmt.Errorf("%s: %v", src, err)
+	}
+	tmp := dst + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+type jpegSegment struct {
+	marker byte
+	data   []byte
+}
+
+func insertSegment(segs []jpegSegment, i int, s jpegSegment) []jpegSegment {
+	segs = append(segs, jpegSegment{})
+	copy(segs[i+1:], segs[i:])
+	segs[i] = s
+	return segs
+}
+
+// jpegSegments splits the JPEG into the segments before the image data,
+// and the rest, starting with the SOS (or EOI) marker.
+func jpegSegments(b []byte) ([]jpegSegment, []byte, error) {
+	var segs []jpegSegment
+	pos := 2
+	for {
+		if pos+2 > len(b) || b[pos] != 0xFF {
+			return nil, nil, fmt.Errorf("bad JPEG marker at %d", pos)
+		}
+		start := pos
+		for pos < len(b) && b[pos] == 0xFF {
+			pos++
+		}
+		if pos+2 >= len(b) {
+			return nil, nil, fmt.Errorf("truncated JPEG at %d", pos)
+		}
+		marker := b[pos]
+		if marker == markerSOS || marker == markerEOI {
+			return segs, b[start:], nil
+		}
+		n := int(binary.BigEndian.Uint16(b[pos+1:]))
+		if n < 2 || pos+1+n > len(b) {
+			return nil, nil, fmt.Errorf("bad JPEG segment length %d at %d", n, pos)
+		}
+		segs = append(segs, jpegSegment{marker: marker, data: b[pos+3 : pos+1+n]})
+		pos += 1 + n
+	}
+}
+
+// TIFF/EXIF
+
+const (
+	tiffByte     = 1
+	tiffASCII    = 2
+	tiffLong     = 4
+	tiffRational = 5
+
+	tagImageDescription = 0x010E
+	tagGPSIFD           = 0x8825
+)
+
+type ifdEntry struct {
+	tag, typ uint16
+	count    uint32
+	// raw is the original 4-byte value or offset field of a kept entry,
+	// data is the value of a new one.
+	raw  []byte
+	data []byte
+}
+
+// embedTIFF returns the TIFF structure (EXIF) with the description and
+// the GPS position set. The original bytes are kept in place (so every
+// offset in them stays valid), and a new IFD0 is appended.
+func embedTIFF(tiff []byte, desc string, point *GeoPoint) ([]byte, error) {
+	if len(tiff) < 8 {
+		tiff = []byte{'I', 'I', 42, 0, 8, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	}
+	var bo binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		bo = binary.LittleEndian
+	case "MM":
+		bo = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("bad TIFF byte order %q", tiff[:2])
+	}
+	entries, next, err := readIFD(tiff, bo, bo.Uint32(tiff[4:]))
+	if err != nil {
+		return nil, err
+	}
+	out := append([]byte(nil), tiff...)
+
+	if desc != "" {
+		entries = setEntry(entries, ifdEntry{tag: tagImageDescription, typ: tiffASCII,
+			count: uint32(len(desc) + 1), data: append([]byte(desc), 0)})
+	}
+	if point != nil {
+		gps := gpsEntries(bo, point)
+		var off uint32
+		out, off = writeIFD(out, bo, gps, 0)
+		v := make([]byte, 4)
+		bo.PutUint32(v, off)
+		entries = setEntry(entries, ifdEntry{tag: tagGPSIFD, typ: tiffLong, count: 1, data: v})
+	}
+	out, off := writeIFD(out, bo, entries, next)
+	bo.PutUint32(out[4:], off)
+	if len(out)+len(exifHeader)+2 > math.MaxUint16 {
+		return nil, fmt.Errorf("EXIF data too long (%d bytes)", len(out))
+	}
+	return out, nil
+}
+
+func readIFD(tiff []byte, bo binary.ByteOrder, off uint32) ([]ifdEntry, uint32, error) {
+	if off == 0 {
+		return nil, 0, nil
+	}
+	if uint64(off)+2 > uint64(len(tiff)) {
+		return nil, 0, fmt.Errorf("IFD offset %d out of range", off)
+	}
+	n := int(bo.Uint16(tiff[off:]))
+	pos := int(off) + 2
+	if pos+12*n+4 > len(tiff) {
+		return nil, 0, fmt.Errorf("IFD at %d with %d entries out of range", off, n)
+	}
+	entries := make([]ifdEntry, n)
+	for i := range entries {
+		e := tiff[pos+12*i:]
+		entries[i] = ifdEntry{tag: bo.Uint16(e), typ: bo.Uint16(e[2:]), count: bo.Uint32(e[4:]), raw: e[8:12]}
+	}
+	return entries, bo.Uint32(tiff[pos+12*n:]), nil
+}
+
+// setEntry replaces or adds the entry, keeping the entries sorted by tag.
+func setEntry(entries []ifdEntry, e ifdEntry) []ifdEntry {
+	for i := range entries {
+		if entries[i].tag == e.tag {
+			entries[i] = e
+			return entries
+		}
+	}
+	entries = append(entries, e)
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].tag < entries[j].tag })
+	return entries
+}
+
+// writeIFD appends the IFD with its out-of-line values to out,
+// and returns the IFD's offset.
+func writeIFD(out []byte, bo binary.ByteOrder, entries []ifdEntry, next uint32) ([]byte, uint32) {
+	if len(out)%2 != 0 {
+		out = append(out, 0)
+	}
+	off := len(out)
+	dataOff := off + 2 + 12*len(entries) + 4
+	ifd := make([]byte, dataOff-off)
+	bo.PutUint16(ifd, uint16(len(entries)))
+	var data []byte
+	for i, e := range entries {
+		b := ifd[2+12*i:]
+		bo.PutUint16(b, e.tag)
+		bo.PutUint16(b[2:], e.typ)
+		bo.PutUint32(b[4:], e.count)
+		switch {
+		case e.raw != nil:
+			copy(b[8:12], e.raw)
+		case len(e.data) <= 4:
+			copy(b[8:12], e.data)
+		default:
+			bo.PutUint32(b[8:], uint32(dataOff+len(data)))
+			data = append(data, e.data...)
+			if len(data)%2 != 0 {
+				data = append(data, 0)
+			}
+		}
+	}
+	bo.PutUint32(ifd[len(ifd)-4:], next)
+	out = append(out, ifd...)
+	return append(out, data...), uint32(off)
+}
+
+func gpsEntries(bo binary.ByteOrder, p *GeoPoint) []ifdEntry {
+	ref := func(deg float64, pos, neg string) []byte {
+		if deg < 0 {
+			return []byte(neg + "\x00")
+		}
+		return []byte(pos + "\x00")
+	}
+	dms := func(deg float64) []byte {
+		deg = math.Abs(deg)
+		d := math.Floor(deg)
+		m := math.Floor((deg - d) * 60)
+		s := (deg - d - m/60) * 3600
+		b := make([]byte, 24)
+		for i, r := range [][2]uint32{{uint32(d), 1}, {uint32(m), 1}, {uint32(math.Round(s * 1000)), 1000}} {
+			bo.PutUint32(b[8*i:], r[0])
+			bo.PutUint32(b[8*i+4:], r[1])
+		}
+		return b
+	}
+	return []ifdEntry{
+		{tag: 0, typ: tiffByte, count: 4, data: []byte{2, 2, 0, 0}},
+		{tag: 1, typ: tiffASCII, count: 2, data: ref(p.Latitude, "N", "S")},
+		{tag: 2, typ: tiffRational, count: 3, data: dms(p.Latitude)},
+		{tag: 3, typ: tiffASCII, count: 2, data: ref(p.Longitude, "E", "W")},
+		{tag: 4, typ: tiffRational, count: 3, data: dms(p.Longitude)},
+	}
+}
+
+// Photoshop image resources and IPTC
+
+const irbIPTC, irbIPTCDigest = 0x0404, 0x0425
+
+// embedIRB returns the Photoshop image resource blocks with the IPTC
+// caption and keywords set; other resources and IPTC datasets are kept.
+func embedIRB(irb []byte, caption string, keywords []string) []byte {
+	var out, iptc []byte
+	for len(irb) >= 12 && string(irb[:4]) == "8BIM" {
+		id := binary.BigEndian.Uint16(irb[4:])
+		nameLen := 1 + int(irb[6])
+		nameLen += nameLen % 2
+		if 6+nameLen+4 > len(irb) {
+			break
+		}
+		size := int(binary.BigEndian.Uint32(irb[6+nameLen:]))
+		end := 6 + nameLen + 4 + size
+		if end > len(irb) {
+			break
+		}
+		data := irb[6+nameLen+4 : end]
+		end += size % 2
+		if end > len(irb) {
+			end = len(irb)
+		}
+		switch id {
+		case irbIPTC:
+			iptc = data
+		case irbIPTCDigest:
+			// The digest of the old IPTC data would be stale.
+		default:
+			out = append(out, irb[:end]...)
+		}
+		irb = irb[end:]
+	}
+
+	iptc = embedIPTC(iptc, caption, keywords)
+	block := []byte{'8', 'B', 'I', 'M', irbIPTC >> 8, irbIPTC & 0xFF, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(block[8:], uint32(len(iptc)))
+	block = append(block, iptc...)
+	if len(iptc)%2 != 0 {
+		block = append(block, 0)
+	}
+	return append(out, block...)
+}
+
+type iimDataset struct {
+	record, id byte
+	data       []byte
+}
+
+// embedIPTC returns the IPTC IIM datasets with the caption (2:120) and
+// keywords (2:25) replaced, marked as UTF-8.
+func embedIPTC(iptc []byte, caption string, keywords []string) []byte {
+	var rec1, rec2, others []iimDataset
+	for len(iptc) >= 5 && iptc[0] == 0x1C {
+		size := int(binary.BigEndian.Uint16(iptc[3:]))
+		if size&0x8000 != 0 || 5+size > len(iptc) {
+			break // extended datasets are not used for these records
+		}
+		ds := iimDataset{record: iptc[1], id: iptc[2], data: iptc[5 : 5+size]}
+		iptc = iptc[5+size:]
+		switch {
+		case ds.record == 1 && ds.id == 90, ds.record == 2 && ds.id == 0,
+			ds.record == 2 && ds.id == 120 && caption != "",
+			ds.record == 2 && ds.id == 25 && len(keywords) != 0:
+		case ds.record == 1:
+			rec1 = append(rec1, ds)
+		case ds.record == 2:
+			rec2 = append(rec2, ds)
+		default:
+			others = append(others, ds)
+		}
+	}
+
+	datasets := append([]iimDataset{{1, 90, []byte("\x1b%G")}}, rec1...)
+	datasets = append(datasets, iimDataset{2, 0, []byte{0, 4}})
+	datasets = append(datasets, rec2...)
+	if caption != "" {
+		datasets = append(datasets, iimDataset{2, 120, []byte(truncateUTF8(caption, 2000))})
+	}
+	for _, k := range keywords {
+		datasets = append(datasets, iimDataset{2, 25, []byte(truncateUTF8(k, 64))})
+	}
+	datasets = append(datasets, others...)
+
+	var out []byte
+	for _, ds := range datasets {
+		out = append(out, 0x1C, ds.record, ds.id, byte(len(ds.data)>>8), byte(len(ds.data)))
+		out = append(out, ds.data...)
+	}
+	return out
+}
+
+// truncateUTF8 shortens s to at most n bytes, on a rune boundary.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,106 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package picago
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+func TestEmbedJPEGMetadata(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 16, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	orig := buf.Bytes()
+	if _, err := EmbedJPEGMetadata([]byte("GIF89a"), Photo{Description: "x"}); err != ErrNotJPEG {
+		t.Errorf("got %v, wanted ErrNotJPEG", err)
+	}
+
+	// A big-endian EXIF with Make, to check that it is kept.
+	tiff := []byte{'M', 'M', 0, 42, 0, 0, 0, 8, 0, 1,
+		0x01, 0x0F, 0, tiffASCII, 0, 0, 0, 4, 'F', 'u', 'j', 0,
+		0, 0, 0, 0}
+	withExif := append([]byte{0xFF, markerSOI, 0xFF, markerAPP1, 0, byte(2 + len(exifHeader) + len(tiff))}, exifHeader...)
+	withExif = append(append(withExif, tiff...), orig[2:]...)
+
+	b, err := EmbedJPEGMetadata(withExif, Photo{
+		Description: "Biking with Blake",
+		Keywords:    []string{"bike", "summer"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the position this time: the caption must stay.
+	if b, err = EmbedJPEGMetadata(b, Photo{Point: &GeoPoint{Latitude: -33.8568, Longitude: 151.2153}}); err != nil {
+		t.Fatal(err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != image.Rect(0, 0, 16, 8) {
+		t.Errorf("got bounds %v", img.Bounds())
+	}
+	if !bytes.HasSuffix(b, orig[bytes.Index(orig, []byte{0xFF, markerSOS}):]) {
+		t.Error("image data changed")
+	}
+
+	segs, _, err := jpegSegments(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exif, irb []byte
+	for _, s := range segs {
+		if s.marker == markerAPP1 && bytes.HasPrefix(s.data, exifHeader) {
+			exif = s.data[len(exifHeader):]
+		} else if s.marker == markerAPP13 && bytes.HasPrefix(s.data, photoshopHeader) {
+			irb = s.data[len(photoshopHeader):]
+		}
+	}
+	bo := binary.BigEndian
+	entries, _, err := readIFD(exif, bo, bo.Uint32(exif[4:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[uint16][]byte)
+	for _, e := range entries {
+		v := e.raw
+		if e.count > 4 || e.typ == tiffRational {
+			off := bo.Uint32(e.raw)
+			v = exif[off:]
+		}
+		values[e.tag] = v
+	}
+	if got := string(values[0x010F][:3]); got != "Fuj" {
+		t.Errorf("Make: got %q", got)
+	}
+	if got := string(values[tagImageDescription][:17]); got != "Biking with Blake" {
+		t.Errorf("ImageDescription: got %q", got)
+	}
+	gps, _, err := readIFD(exif, bo, bo.Uint32(values[tagGPSIFD]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gps) != 5 || string(gps[1].raw[:1]) != "S" || string(gps[3].raw[:1]) != "E" {
+		t.Errorf("bad GPS IFD: %+v", gps)
+	}
+	lat := exif[bo.Uint32(gps[2].raw):]
+	if d, m := bo.Uint32(lat), bo.Uint32(lat[8:]); d != 33 || m != 51 {
+		t.Errorf("latitude: got %d°%d'", d, m)
+	}
+
+	if !bytes.HasPrefix(irb, []byte("8BIM\x04\x04")) {
+		t.Fatalf("no IPTC in %q", irb)
+	}
+	for _, want := range []string{"\x1c\x02\x78\x00\x11Biking with Blake", "\x1c\x02\x19\x00\x04bike", "\x1c\x02\x19\x00\x06summer"} {
+		if !bytes.Contains(irb, []byte(want)) {
+			t.Errorf("IPTC: no %q in %q", want, irb)
+		}
+	}
+}
@@ -0,0 +1,588 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package picago
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseJSON parses a GData JSON (alt=json) feed into the same structure
+// as ParseAtom does for the Atom XML feed.
+func ParseJSON(r io.Reader) (*Atom, error) {
+	fd := NewJSONFeedDecoder(r)
+	var entries []Entry
+	for {
+		e, err := fd.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *e)
+	}
+	result := fd.Feed()
+	result.Entries = entries
+	return result, nil
+}
+
+// NewJSONFeedDecoder returns a FeedDecoder reading GData JSON from r.
+// r may contain a feed or a single entry.
+func NewJSONFeedDecoder(r io.Reader) *FeedDecoder {
+	jd := &jsonFeedDecoder{d: json.NewDecoder(r)}
+	return &FeedDecoder{next: jd.next}
+}
+
+const (
+	jsonStart = iota
+	jsonInRoot
+	jsonInFeed
+	jsonInEntries
+	jsonDone
+)
+
+type jsonFeedDecoder struct {
+	d     *json.Decoder
+	state int
+}
+
+func (jd *jsonFeedDecoder) next(feed *Atom) (*Entry, error) {
+	for {
+		switch jd.state {
+		case jsonDone:
+			return nil, io.EOF
+
+		case jsonStart:
+			if err := jd.expect('{'); err != nil {
+				return nil, err
+			}
+			jd.state = jsonInRoot
+
+		case jsonInRoot:
+			if !jd.d.More() {
+				if err := jd.expect('}'); err != nil {
+					return nil, err
+				}
+				jd.state = jsonDone
+				continue
+			}
+			key, err := jd.key()
+			if err != nil {
+				return nil, err
+			}
+			switch key {
+			case "feed":
+				if err := jd.expect('{'); err != nil {
+					return nil, err
+				}
+				jd.state = jsonInFeed
+			case "entry":
+				return jd.decodeEntry()
+			default:
+				if err := jd.skip(); err != nil {
+					return nil, err
+				}
+			}
+
+		case jsonInFeed:
+			if !jd.d.More() {
+				if err := jd.expect('}'); err != nil {
+					return nil, err
+				}
+				jd.state = jsonInRoot
+				continue
+			}
+			key, err := jd.key()
+			if err != nil {
+				return nil, err
+			}
+			if key == "entry" {
+				if err := jd.expect('['); err != nil {
+					return nil, err
+				}
+				jd.state = jsonInEntries
+				continue
+			}
+			if err := jd.decodeMeta(feed, key); err != nil {
+				return nil, err
+			}
+
+		case jsonInEntries:
+			if !jd.d.More() {
+				if err := jd.expect(']'); err != nil {
+					return nil, err
+				}
+				jd.state = jsonInFeed
+				continue
+			}
+			return jd.decodeEntry()
+		}
+	}
+}
+
+func (jd *jsonFeedDecoder) fail(err error) error {
+	jd.state = jsonDone
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (jd *jsonFeedDecoder) expect(delim json.Delim) error {
+	tok, err := jd.d.Token()
+	if err != nil {
+		return jd.fail(err)
+	}
+	if d, ok := tok.(json.Delim); !ok || d != delim {
+		return jd.fail(fmt.Errorf("got %v, wanted %q", tok, delim))
+	}
+	return nil
+}
+
+func (jd *jsonFeedDecoder) key() (string, error) {
+	tok, err := jd.d.Token()
+	if err != nil {
+		return "", jd.fail(err)
+	}
+	key, ok := tok.(string)
+	if !ok {
+		return "", jd.fail(fmt.Errorf("got %v, wanted a key", tok))
+	}
+	return key, nil
+}
+
+func (jd *jsonFeedDecoder) skip() error {
+	var raw json.RawMessage
+	if err := jd.d.Decode(&raw); err != nil {
+		return jd.fail(err)
+	}
+	return nil
+}
+
+func (jd *jsonFeedDecoder) decodeEntry() (*Entry, error) {
+	var je jsonEntry
+	if err := jd.d.Decode(&je); err != nil {
+		return nil, jd.fail(err)
+	}
+	e, err := je.entry()
+	if err != nil {
+		return nil, jd.fail(err)
+	}
+	return e, nil
+}
+
+// decodeMeta decodes the value of key of the feed object into the matching
+// field of Atom.
+func (jd *jsonFeedDecoder) decodeMeta(f *Atom, key string) error {
+	if key == "category" {
+		var cats []jsonCategory
+		if err := jd.d.Decode(&cats); err != nil {
+			return jd.fail(err)
+		}
+		f.Categories = categories(cats)
+		return nil
+	}
+	if key == "author" {
+		var authors []jsonAuthor
+		if err := jd.d.Decode(&authors); err != nil {
+			return jd.fail(err)
+		}
+		if len(authors) != 0 {
+			f.Author = authors[0].author()
+		}
+		return nil
+	}
+	switch key {
+	case "id", "gphoto$name", "updated", "title", "subtitle", "icon", "gphoto$thumbnail",
+		"gphoto$numphotos", "openSearch$startIndex", "openSearch$totalResults", "openSearch$itemsPerPage":
+	default:
+		return jd.skip()
+	}
+	var t jsonText
+	if err := jd.d.Decode(&t); err != nil {
+		return jd.fail(err)
+	}
+	var c jsonConv
+	switch key {
+	case "id":
+		f.ID = t.String()
+	case "gphoto$name":
+		f.Name = t.String()
+	case "updated":
+		f.Updated = c.time(t)
+	case "title":
+		f.Title = t.String()
+	case "subtitle":
+		f.Subtitle = t.String()
+	case "icon":
+		f.Icon = t.String()
+	case "gphoto$thumbnail":
+		f.Thumbnail = t.String()
+	case "gphoto$numphotos":
+		f.NumPhotos = c.int(t)
+	case "openSearch$startIndex":
+		f.StartIndex = c.int(t)
+	case "openSearch$totalResults":
+		f.TotalResults = c.int(t)
+	case "openSearch$itemsPerPage":
+		f.ItemsPerPage = c.int(t)
+	}
+	if c.err != nil {
+		return jd.fail(fmt.Errorf("%s: %v", key, c.err))
+	}
+	return nil
+}
+
+// jsonScalar is a JSON string, number or boolean, kept as its text.
+type jsonScalar string
+
+func (s *jsonScalar) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
+	switch {
+	case len(b) == 0 || string(b) == "null":
+		*s = ""
+	case b[0] == '"':
+		var str string
+		if err := json.Unmarshal(b, &str); err != nil {
+			return err
+		}
+		*s = jsonScalar(str)
+	case b[0] == '{' || b[0] == '[':
+		return fmt.Errorf("wanted a scalar, got %s", b)
+	default:
+		*s = jsonScalar(b)
+	}
+	return nil
+}
+
+// jsonText is the GData JSON representation of an element's text.
+type jsonText struct {
+	T jsonScalar `json:"$t"`
+}
+
+func (t jsonText) String() string { return string(t.T) }
+
+type jsonLink struct {
+	Rel  jsonScalar `json:"rel"`
+	Type jsonScalar `json:"type"`
+	Href jsonScalar `json:"href"`
+}
+
+type jsonCategory struct {
+	Scheme jsonScalar `json:"scheme"`
+	Term   jsonScalar `json:"term"`
+}
+
+type jsonAuthor struct {
+	Name jsonText `json:"name"`
+	URI  jsonText `json:"uri"`
+}
+
+func categories(cats []jsonCategory) []Category {
+	var res []Category
+	for _, c := range cats {
+		res = append(res, Category{Scheme: string(c.Scheme), Term: string(c.Term)})
+	}
+	return res
+}
+
+func (a jsonAuthor) author() Author {
+	return Author{Name: a.Name.String(), URI: a.URI.String()}
+}
+
+type jsonMediaContent struct {
+	URL    jsonScalar `json:"url"`
+	Type   jsonScalar `json:"type"`
+	Width  jsonScalar `json:"width"`
+	Height jsonScalar `json:"height"`
+	Medium jsonScalar `json:"medium"`
+}
+
+type jsonMedia struct {
+	Title       jsonText           `json:"media$title"`
+	Description jsonText           `json:"media$description"`
+	Keywords    jsonText           `json:"media$keywords"`
+	Content     []jsonMediaContent `json:"media$content"`
+	Thumbnail   []jsonMediaContent `json:"media$thumbnail"`
+}
+
+type jsonExif struct {
+	Make        *jsonText `json:"exif$make"`
+	Model       *jsonText `json:"exif$model"`
+	Lens        *jsonText `json:"exif$lens"`
+	FStop       *jsonText `json:"exif$fstop"`
+	Exposure    *jsonText `json:"exif$exposure"`
+	FocalLength *jsonText `json:"exif$focallength"`
+	Distance    *jsonText `json:"exif$distance"`
+	ISO         *jsonText `json:"exif$iso"`
+	Flash       *jsonText `json:"exif$flash"`
+	Orientation *jsonText `json:"exif$orientation"`
+	Timestamp   *jsonText `json:"exif$time"`
+	UID         *jsonText `json:"exif$imageUniqueID"`
+	Latitude    *jsonText `json:"exif$gpsLatitude"`
+	Longitude   *jsonText `json:"exif$gpsLongitude"`
+	Altitude    *jsonText `json:"exif$gpsAltitude"`
+}
+
+type jsonWhere struct {
+	Point *struct {
+		Pos jsonText `json:"gml$pos"`
+	} `json:"gml$Point"`
+	Envelope *struct {
+		Lower jsonText `json:"gml$lowerCorner"`
+		Upper jsonText `json:"gml$upperCorner"`
+	} `json:"gml$Envelope"`
+}
+
+type jsonEntry struct {
+	ETag      jsonScalar   `json:"gd$etag"`
+	EntryID   jsonText     `json:"id"`
+	ID        jsonText     `json:"gphoto$id"`
+	Published jsonText     `json:"published"`
+	Updated   jsonText     `json:"updated"`
+	Name      jsonText     `json:"gphoto$name"`
+	Title     jsonText     `json:"title"`
+	Summary   jsonText     `json:"summary"`
+	Rights    jsonText     `json:"rights"`
+	AlbumType jsonText     `json:"gphoto$albumType"`
+	Links     []jsonLink   `json:"link"`
+	Author    []jsonAuthor `json:"author"`
+
+	Categories []jsonCategory `json:"category"`
+	Location   jsonText       `json:"gphoto$location"`
+	NumPhotos  jsonText       `json:"gphoto$numphotos"`
+	Content    struct {
+		Type jsonScalar `json:"type"`
+		Src  jsonScalar `json:"src"`
+		Text jsonScalar `json:"$t"`
+	} `json:"content"`
+	Media *jsonMedia `json:"media$group"`
+	Exif  *jsonExif  `json:"exif$tags"`
+	Where *jsonWhere `json:"georss$where"`
+
+	NumPhotosRemaining jsonText  `json:"gphoto$numphotosremaining"`
+	BytesUsed          jsonText  `json:"gphoto$bytesUsed"`
+	Access             jsonText  `json:"gphoto$access"`
+	Timestamp          jsonText  `json:"gphoto$timestamp"`
+	CommentingEnabled  jsonText  `json:"gphoto$commentingEnabled"`
+	AllowDownloads     *jsonText `json:"gphoto$allowDownloads"`
+	AllowPrints        *jsonText `json:"gphoto$allowPrints"`
+
+	AlbumID      jsonText `json:"gphoto$albumid"`
+	Checksum     jsonText `json:"gphoto$checksum"`
+	Size         jsonText `json:"gphoto$size"`
+	Rotation     jsonText `json:"gphoto$rotation"`
+	CommentCount jsonText `json:"gphoto$commentCount"`
+	Version      jsonText `json:"gphoto$version"`
+
+	User      jsonText `json:"gphoto$user"`
+	Nickname  jsonText `json:"gphoto$nickname"`
+	Thumbnail jsonText `json:"gphoto$thumbnail"`
+	PhotoID   jsonText `json:"gphoto$photoid"`
+	Weight    jsonText `json:"gphoto$weight"`
+}
+
+func (je jsonEntry) entry() (*Entry, error) {
+	var c jsonConv
+	e := &Entry{
+		ETag:      string(je.ETag),
+		EntryID:   je.EntryID.String(),
+		ID:        je.ID.String(),
+		Published: c.time(je.Published),
+		Updated:   c.time(je.Updated),
+		Name:      je.Name.String(),
+		Title:     je.Title.String(),
+		Summary:   je.Summary.String(),
+		Rights:    je.Rights.String(),
+		AlbumType: je.AlbumType.String(),
+		Location:  je.Location.String(),
+		NumPhotos: c.int(je.NumPhotos),
+		Content:   EntryContent{URL: string(je.Content.Src), Type: string(je.Content.Type), Text: string(je.Content.Text)},
+
+		NumPhotosRemaining: c.int(je.NumPhotosRemaining),
+		BytesUsed:          c.int64(je.BytesUsed),
+		Access:             je.Access.String(),
+		Timestamp:          c.int64(je.Timestamp),
+		CommentingEnabled:  c.bool(je.CommentingEnabled),
+		AllowDownloads:     c.boolPtr(je.AllowDownloads),
+		AllowPrints:        c.boolPtr(je.AllowPrints),
+
+		AlbumID:      je.AlbumID.String(),
+		Checksum:     je.Checksum.String(),
+		Size:         c.int64(je.Size),
+		Rotation:     c.int(je.Rotation),
+		CommentCount: c.int(je.CommentCount),
+		Version:      c.int64(je.Version),
+
+		User:      je.User.String(),
+		Nickname:  je.Nickname.String(),
+		Thumbnail: je.Thumbnail.String(),
+		PhotoID:   je.PhotoID.String(),
+		Weight:    c.int(je.Weight),
+	}
+	for _, l := range je.Links {
+		e.Links = append(e.Links, Link{Rel: string(l.Rel), Type: string(l.Type), URL: string(l.Href)})
+	}
+	if len(je.Author) != 0 {
+		e.Author = je.Author[0].author()
+	}
+	e.Categories = categories(je.Categories)
+	if m := je.Media; m != nil {
+		e.Media = &Media{
+			Title:       m.Title.String(),
+			Description: m.Description.String(),
+			Keywords:    m.Keywords.String(),
+			Content:     c.mediaContents(m.Content),
+			Thumbnail:   c.mediaContents(m.Thumbnail),
+		}
+	}
+	if x := je.Exif; x != nil {
+		e.Exif = &Exif{
+			Make:        c.string(x.Make),
+			Model:       c.string(x.Model),
+			Lens:        c.string(x.Lens),
+			FStop:       c.floatPtr(x.FStop),
+			Exposure:    c.floatPtr(x.Exposure),
+			FocalLength: c.floatPtr(x.FocalLength),
+			Distance:    c.floatPtr(x.Distance),
+			ISO:         c.intPtr(x.ISO),
+			Flash:       c.boolPtr(x.Flash),
+			Orientation: c.intPtr(x.Orientation),
+			Timestamp:   c.int64Ptr(x.Timestamp),
+			UID:         c.string(x.UID),
+			Latitude:    c.floatPtr(x.Latitude),
+			Longitude:   c.floatPtr(x.Longitude),
+			Altitude:    c.floatPtr(x.Altitude),
+		}
+	}
+	if w := je.Where; w != nil {
+		if w.Point != nil {
+			e.Point = w.Point.Pos.String()
+		}
+		if w.Envelope != nil {
+			e.LowerCorner, e.UpperCorner = w.Envelope.Lower.String(), w.Envelope.Upper.String()
+		}
+	}
+	if c.err != nil {
+		return nil, fmt.Errorf("entry %q: %v", e.EntryID, c.err)
+	}
+	return e, nil
+}
+
+// jsonConv converts jsonTexts the way encoding/xml converts character
+// data, remembering the first error.
+type jsonConv struct {
+	err error
+}
+
+func (c *jsonConv) setErr(err error) {
+	if err != nil && c.err == nil {
+		c.err = err
+	}
+}
+
+func (c *jsonConv) string(t *jsonText) string {
+	if t == nil {
+		return ""
+	}
+	return t.String()
+}
+
+func (c *jsonConv) time(t jsonText) time.Time {
+	var tm time.Time
+	if t.T != "" {
+		c.setErr(tm.UnmarshalText([]byte(t.T)))
+	}
+	return tm
+}
+
+func (c *jsonConv) int64(t jsonText) int64 {
+	s := strings.TrimSpace(string(t.T))
+	if s == "" {
+		return 0
+	}
+	i, err := strconv.ParseInt(s, 10, 64)
+	c.setErr(err)
+	return i
+}
+
+func (c *jsonConv) int(t jsonText) int {
+	return int(c.int64(t))
+}
+
+func (c *jsonConv) bool(t jsonText) bool {
+	s := strings.TrimSpace(string(t.T))
+	if s == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(s)
+	c.setErr(err)
+	return b
+}
+
+func (c *jsonConv) float(t jsonText) float64 {
+	s := strings.TrimSpace(string(t.T))
+	if s == "" {
+		return 0
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	c.setErr(err)
+	return f
+}
+
+func (c *jsonConv) boolPtr(t *jsonText) *bool {
+	if t == nil {
+		return nil
+	}
+	b := c.bool(*t)
+	return &b
+}
+
+func (c *jsonConv) intPtr(t *jsonText) *int {
+	if t == nil {
+		return nil
+	}
+	i := c.int(*t)
+	return &i
+}
+
+func (c *jsonConv) int64Ptr(t *jsonText) *int64 {
+	if t == nil {
+		return nil
+	}
+	i := c.int64(*t)
+	return &i
+}
+
+func (c *jsonConv) floatPtr(t *jsonText) *float64 {
+	if t == nil {
+		return nil
+	}
+	f := c.float(*t)
+	return &f
+}
+
+func (c *jsonConv) mediaContents(mcs []jsonMediaContent) []MediaContent {
+	if len(mcs) == 0 {
+		return nil
+	}
+	res := make([]MediaContent, len(mcs))
+	for i, mc := range mcs {
+		res[i] = MediaContent{
+			URL:    string(mc.URL),
+			Type:   string(mc.Type),
+			Width:  c.int(jsonText{T: mc.Width}),
+			Height: c.int(jsonText{T: mc.Height}),
+			Medium: string(mc.Medium),
+		}
+	}
+	return res
+}
@@ -0,0 +1,68 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package picago
+
+import (
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestJSONMatchesXML checks that the GData JSON and the Atom XML
+// forms of the same feed decode to the same entries.
+func TestJSONMatchesXML(t *testing.T) {
+	for _, name := range []string{
+		"testdata/album-list",
+		"testdata/gallery-with-a-video",
+	} {
+		fn := name + ".json"
+		want := mustParseAtom(t, name+".xml")
+		f, err := os.Open(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ParseJSON(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", fn, err)
+		}
+		if got.TotalResults != want.TotalResults || got.Updated != want.Updated || got.Author != want.Author {
+			t.Errorf("%s: feed metadata differs:\n got %+v\nwant %+v", fn, got, want)
+		}
+		if len(got.Entries) != len(want.Entries) {
+			t.Fatalf("%s: got %d entries; want %d", fn, len(got.Entries), len(want.Entries))
+		}
+		for i := range want.Entries {
+			ge, we := &got.Entries[i], &want.Entries[i]
+			if !reflect.DeepEqual(ge, we) {
+				t.Errorf("%s[%d]: entry differs:\n got %+v\nwant %+v", fn, i, ge, we)
+			}
+			if !reflect.DeepEqual(ge.album(), we.album()) {
+				t.Errorf("%s[%d]: album differs:\n got %+v\nwant %+v", fn, i, ge.album(), we.album())
+			}
+			gp, gerr := ge.photo()
+			wp, werr := we.photo()
+			if !reflect.DeepEqual(gp, wp) || (gerr == nil) != (werr == nil) {
+				t.Errorf("%s[%d]: photo differs:\n got %+v (%v)\nwant %+v (%v)", fn, i, gp, gerr, wp, werr)
+			}
+		}
+	}
+}
+
+func TestJSONSingleEntry(t *testing.T) {
+	fd := NewJSONFeedDecoder(strings.NewReader(`{"version":"1.0","entry":{"title":{"$t":"a.jpg"},"gphoto$size":{"$t":12}}}`))
+	e, err := fd.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Title != "a.jpg" || e.Size != 12 {
+		t.Errorf("got %+v", e)
+	}
+	if _, err = fd.Next(); err != io.EOF {
+		t.Errorf("after the single entry got %v; want EOF", err)
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package picago
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Kind is the kind of a feed or entry, from its kind category.
+type Kind string
+
+const (
+	KindUser    = Kind("user")
+	KindAlbum   = Kind("album")
+	KindPhoto   = Kind("photo")
+	KindTag     = Kind("tag")
+	KindComment = Kind("comment")
+)
+
+const (
+	kindScheme = "http://schemas.google.com/g/2005#kind"
+	kindPrefix = "http://schemas.google.com/photos/2007#"
+)
+
+// Category returns the kind category.
+func (k Kind) Category() Category {
+	return Category{Scheme: kindScheme, Term: kindPrefix + string(k)}
+}
+
+func kindOf(categories []Category) Kind {
+	for _, c := range categories {
+		if c.Scheme == kindScheme && strings.HasPrefix(c.Term, kindPrefix) {
+			return Kind(c.Term[len(kindPrefix):])
+		}
+	}
+	return ""
+}
+
+// Kind returns the kind of the feed, or the empty string if unknown.
+//
+// Note that the kind of the feed is the kind of its owner: a user's feed
+// contains albums, an album's feed contains photos.
+func (a *Atom) Kind() Kind { return kindOf(a.Categories) }
+
+// Kind returns the kind of the entry, or the empty string if unknown.
+func (e *Entry) Kind() Kind { return kindOf(e.Categories) }
+
+// A Tag is a keyword with the number of its uses.
+type Tag struct {
+	Name   string
+	Weight int
+}
+
+// A Comment is a comment on a photo.
+type Comment struct {
+	ID, PhotoID, AlbumID  string
+	Text                  string
+	AuthorName, AuthorURI string
+	Published, Updated    time.Time
+}
+
+func (e *Entry) tag() Tag {
+	return Tag{Name: e.Title, Weight: e.Weight}
+}
+
+func (e *Entry) comment() Comment {
+	return Comment{
+		ID:         e.ID,
+		PhotoID:    e.PhotoID,
+		AlbumID:    e.AlbumID,
+		Text:       e.Content.Text,
+		AuthorName: e.Author.Name,
+		AuthorURI:  e.Author.URI,
+		Published:  e.Published,
+		Updated:    e.Updated,
+	}
+}
+
+func (e *Entry) user() User {
+	return User{ID: e.User, URI: e.Author.URI, Name: e.Nickname, Thumbnail: e.Thumbnail}
+}
+
+// Value returns the entry converted to the Go type of its kind:
+// Album, Photo, Tag, Comment or User.
+func (e *Entry) Value() (interface{}, error) {
+	switch k := e.Kind(); k {
+	case KindAlbum:
+		return e.album(), nil
+	case KindPhoto:
+		return e.photo()
+	case KindTag:
+		return e.tag(), nil
+	case KindComment:
+		return e.comment(), nil
+	case KindUser:
+		return e.user(), nil
+	default:
+		return nil, fmt.Errorf("entry %q: unknown kind %q", e.EntryID, k)
+	}
+}
+
+// A Feed is a feed with its entries converted to their Go types.
+type Feed struct {
+	// Atom is the feed-level metadata, without the entries.
+	*Atom
+
+	Albums   []Album
+	Photos   []Photo
+	Tags     []Tag
+	Comments []Comment
+	Users    []User
+}
+
+// DecodeFeed decodes any feed, in Atom XML or GData JSON format,
+// converting each entry to the Go type of its kind.
+// Entries of unknown kind are skipped.
+func DecodeFeed(r io.Reader) (*Feed, error) {
+	br := bufio.NewReader(r)
+	fd := NewFeedDecoder(br)
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		if b[0] == ' ' || b[0] == '\t' || b[0] == '\r' || b[0] == '\n' {
+			br.ReadByte()
+			continue
+		}
+		if b[0] == '{' {
+			fd = NewJSONFeedDecoder(br)
+		}
+		break
+	}
+
+	feed := &Feed{Atom: fd.Feed()}
+	for {
+		e, err := fd.Next()
+		if err == io.EOF {
+			return feed, nil
+		}
+		if err != nil {
+			return feed, err
+		}
+		if e.Kind() == "" {
+			continue
+		}
+		v, err := e.Value()
+		if err != nil {
+			return feed, err
+		}
+		switch x := v.(type) {
+		case Album:
+			feed.Albums = append(feed.Albums, x)
+		case Photo:
+			x.Position = feed.StartIndex + len(feed.Photos)
+			if feed.StartIndex == 0 {
+				x.Position++
+			}
+			feed.Photos = append(feed.Photos, x)
+		case Tag:
+			feed.Tags = append(feed.Tags, x)
+		case Comment:
+			feed.Comments = append(feed.Comments, x)
+		case User:
+			feed.Users = append(feed.Users, x)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package picago
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// AlbumSidecarName is the name of the album's metadata file in its directory.
+const AlbumSidecarName = "album.json"
+
+// AlbumDirName returns the name of the album's directory: its title (or
+// name, if the title is empty) followed by its ID in brackets, so it is
+// unique and can be found after the title changes.
+func AlbumDirName(a Album) string {
+	title := a.Title
+	if title == "" {
+		title = a.Name
+	}
+	return withSuffix(SanitiseName(title), " ["+a.ID+"]", "")
+}
+
+// albumIDOfDir returns the album ID from a name returned by AlbumDirName,
+// or the empty string.
+func albumIDOfDir(name string) string {
+	if !strings.HasSuffix(name, "]") {
+		return ""
+	}
+	i := strings.LastIndex(name, " [")
+	if i < 0 {
+		return ""
+	}
+	return name[i+2 : len(name)-1]
+}
+
+// PhotoFileNames returns the file names of the photos of an album,
+// keyed by photo ID.
+//
+// The name is the photo's Filename (or its ID, if that is empty), made
+// safe by SanitiseName. If more photos share a name (ignoring case), the
+// one with the smallest ID keeps it, the others get their ID appended, so
+// the result doesn't depend on the order of the photos.
+func PhotoFileNames(photos []Photo) map[string]string {
+	sorted := make([]Photo, len(photos))
+	copy(sorted, photos)
+	sort.Slice(sorted, func(i, j int) bool { return lessID(sorted[i].ID, sorted[j].ID) })
+
+	names := make(map[string]string, len(photos))
+	used := make(map[string]bool, len(photos))
+	for _, p := range sorted {
+		name := p.Filename
+		if name == "" {
+			name = p.ID
+		}
+		name = SanitiseName(name)
+		if used[foldName(name)] {
+			ext := filepath.Ext(name)
+			base, suffix := name[:len(name)-len(ext)], "_"+SanitiseName(p.ID)
+			name = withSuffix(base, suffix, ext)
+			for i := 2; used[foldName(name)]; i++ {
+				name = withSuffix(base, suffix+"_"+strconv.Itoa(i), ext)
+			}
+		}
+		used[foldName(name)] = true
+		names[p.ID] = name
+	}
+	return names
+}
+
+// lessID orders numeric IDs numerically, others lexically.
+func lessID(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+// A Layout is a Destination which puts the photos into
+// Dir/AlbumDirName(album)/PhotoFileNames(photos)[photo.ID].
+//
+// Register each album with AddAlbum before downloading its photos.
+type Layout struct {
+	Dir string
+
+	names photoNames
+}
+
+// NewLayout returns a Layout rooted at dir.
+func NewLayout(dir string) *Layout {
+	return &Layout{Dir: dir}
+}
+
+// AddAlbum registers the photos of the album, and prepares its directory:
+// an existing directory of the album under a previous title is renamed,
+// otherwise it is created. It returns the path of the directory.
+func (l *Layout) AddAlbum(a Album, photos []Photo) (string, error) {
+	dir := filepath.Join(l.Dir, AlbumDirName(a))
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if old := l.findAlbumDir(a.ID); old != "" {
+			if err = os.Rename(old, dir); err != nil {
+				return dir, err
+			}
+		}
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return dir, err
+	}
+	l.names.set(a.ID, photos)
+	return dir, nil
+}
+
+// PlanAlbum registers the photos of the album like AddAlbum, without
+// touching the disk. It returns the path of the album's directory, and
+// its existing directory under a previous title, which AddAlbum would
+// rename (empty if there is none).
+func (l *Layout) PlanAlbum(a Album, photos []Photo) (dir, oldDir string) {
+	dir = filepath.Join(l.Dir, AlbumDirName(a))
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		oldDir = l.findAlbumDir(a.ID)
+	}
+	l.names.set(a.ID, photos)
+	return dir, oldDir
+}
+
+// findAlbumDir returns the existing directory of the album with the given ID.
+func (l *Layout) findAlbumDir(id string) string {
+	fis, err := ioutil.ReadDir(l.Dir)
+	if err != nil {
+		return ""
+	}
+	for _, fi := range fis {
+		if fi.IsDir() && albumIDOfDir(fi.Name()) == id {
+			return filepath.Join(l.Dir, fi.Name())
+		}
+	}
+	return ""
+}
+
+// Path returns the path of the photo, implementing Destination.
+func (l *Layout) Path(a Album, p Photo) (string, error) {
+	return filepath.Join(l.Dir, AlbumDirName(a), l.names.get(a.ID, p)), nil
+}
+
+// photoNames holds the PhotoFileNames of the registered albums.
+type photoNames struct {
+	mu sync.Mutex
+	m  map[string]map[string]string
+}
+
+func (n *photoNames) set(albumID string, photos []Photo) {
+	names := PhotoFileNames(photos)
+	n.mu.Lock()
+	if n.m == nil {
+		n.m = make(map[string]map[string]string)
+	}
+	n.m[albumID] = names
+	n.mu.Unlock()
+}
+
+// get returns the name of the photo, or its own cleaned name if its album
+// is not registered.
+func (n *photoNames) get(albumID string, p Photo) string {
+	n.mu.Lock()
+	name := n.m[albumID][p.ID]
+	n.mu.Unlock()
+	if name == "" {
+		name = PhotoFileNames([]Photo{p})[p.ID]
+	}
+	return name
+}
@@ -0,0 +1,99 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package picago
+
+import "strings"
+
+// A MediaSelector chooses one of the media variants of a photo or video.
+// It returns false if none of the variants is acceptable.
+type MediaSelector func([]MediaContent) (MediaContent, bool)
+
+// DefaultMediaSelector is used to fill Photo.URL, Type, Width and Height.
+var DefaultMediaSelector MediaSelector = LargestVideo
+
+// Select returns the variant of the photo chosen by sel.
+// If sel is nil, DefaultMediaSelector is used.
+func (p Photo) Select(sel MediaSelector) (MediaContent, bool) {
+	if sel == nil {
+		sel = DefaultMediaSelector
+	}
+	return sel(p.Media)
+}
+
+// IsVideo reports whether the photo is a video, i.e. it has a video variant.
+func (p Photo) IsVideo() bool {
+	for _, mc := range p.Media {
+		if mc.Medium == "video" {
+			return true
+		}
+	}
+	return false
+}
+
+// Largest selects the variant with the most pixels, regardless of its kind.
+func Largest(variants []MediaContent) (MediaContent, bool) {
+	return largest(variants, func(MediaContent) bool { return true })
+}
+
+// LargestVideo selects the largest non-Flash video, or if there is none,
+// the largest variant of any kind.
+func LargestVideo(variants []MediaContent) (MediaContent, bool) {
+	if mc, ok := largest(variants, func(mc MediaContent) bool {
+		return mc.Medium == "video" && mc.Type != "application/x-shockwave-flash"
+	}); ok {
+		return mc, true
+	}
+	return Largest(variants)
+}
+
+// OriginalImage selects the largest image variant. For photos fetched
+// with imgmax=d this is the original, for videos it is the still image.
+func OriginalImage(variants []MediaContent) (MediaContent, bool) {
+	return largest(variants, func(mc MediaContent) bool { return mc.Medium == "image" })
+}
+
+// MaxDimension returns a MediaSelector which selects the largest variant
+// whose width and height are both at most max pixels.
+func MaxDimension(max int) MediaSelector {
+	return func(variants []MediaContent) (MediaContent, bool) {
+		return largest(variants, func(mc MediaContent) bool {
+			return mc.Width <= max && mc.Height <= max
+		})
+	}
+}
+
+// PreferType returns a MediaSelector which selects the largest variant
+// with the given MIME type (or type prefix, such as "video/"),
+// and falls back to fallback (if not nil) when there is none.
+func PreferType(mimeType string, fallback MediaSelector) MediaSelector {
+	return func(variants []MediaContent) (MediaContent, bool) {
+		if mc, ok := largest(variants, func(mc MediaContent) bool {
+			if strings.HasSuffix(mimeType, "/") {
+				return strings.HasPrefix(mc.Type, mimeType)
+			}
+			return mc.Type == mimeType
+		}); ok {
+			return mc, true
+		}
+		if fallback == nil {
+			return MediaContent{}, false
+		}
+		return fallback(variants)
+	}
+}
+
+func largest(variants []MediaContent, accept func(MediaContent) bool) (ret MediaContent, ok bool) {
+	var bestPixels int64 = -1
+	for _, mc := range variants {
+		if !accept(mc) {
+			continue
+		}
+		thisPixels := int64(mc.Width) * int64(mc.Height)
+		if thisPixels > bestPixels {
+			ret, ok, bestPixels = mc, true, thisPixels
+		}
+	}
+	return ret, ok
+}
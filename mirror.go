@@ -0,0 +1,527 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package picago
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// sidecarExts are the extensions of the files which go with a photo.
+var sidecarExts = []string{".json", ".xmp"}
+
+// ManifestName is the default name of the manifest file in a mirror.
+const ManifestName = ".picago-manifest.json"
+
+const manifestVersion = 1
+
+// A Manifest records the state of a local mirror: which albums and photos
+// have been downloaded, in which version, and where to.
+type Manifest struct {
+	Version int
+	UserID  string
+	Synced  time.Time
+	Albums  map[string]*MirroredAlbum
+}
+
+// A MirroredAlbum is the state of an album in a Manifest.
+type MirroredAlbum struct {
+	ID, ETag    string
+	Name, Title string
+	Updated     time.Time
+
+	// Dir is the album's directory, relative to the mirror's root,
+	// with slash separators.
+	Dir string
+
+	// Complete is true if all the photos of this version of the album
+	// have been downloaded.
+	Complete bool
+
+	// Selection is the photo selection of the Filter (see Filter.PhotoKey)
+	// the album has been completed with.
+	Selection string `json:",omitempty"`
+
+	Photos map[string]*MirroredPhoto
+}
+
+// A MirroredPhoto is the state of a photo in a Manifest.
+type MirroredPhoto struct {
+	ID, ETag string
+	Updated  time.Time
+
+	// Path is the photo's file, relative to the mirror's root,
+	// with slash separators.
+	Path string
+	Size int64
+
+	// Complete is false while the download is in progress.
+	Complete bool
+}
+
+// LoadManifest reads the manifest from path.
+// A missing file results in an empty Manifest.
+func LoadManifest(path string) (*Manifest, error) {
+	m := &Manifest{Version: manifestVersion, Albums: make(map[string]*MirroredAlbum)}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, nil
+		}
+		return nil, err
+	}
+	if err = json.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("parsing manifest %s: %v", path, err)
+	}
+	if m.Version > manifestVersion {
+		return nil, fmt.Errorf("manifest %s: unknown version %d", path, m.Version)
+	}
+	if m.Albums == nil {
+		m.Albums = make(map[string]*MirroredAlbum)
+	}
+	for _, a := range m.Albums {
+		if a.Photos == nil {
+			a.Photos = make(map[string]*MirroredPhoto)
+		}
+	}
+	return m, nil
+}
+
+// Save writes the manifest to path atomically.
+func (m *Manifest) Save(path string) error {
+	m.Version = manifestVersion
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// A SyncOp is the kind of change done by a Mirror.
+type SyncOp string
+
+const (
+	SyncAdd    = SyncOp("add")
+	SyncUpdate = SyncOp("update")
+	SyncRename = SyncOp("rename")
+	SyncDelete = SyncOp("delete")
+	SyncSkip   = SyncOp("skip")
+)
+
+// A SyncEvent reports a change (or the failure of one) done by a Mirror.
+// PhotoID is empty for album-level events.
+type SyncEvent struct {
+	Op               SyncOp
+	AlbumID, PhotoID string
+
+	// Path is the local path of the album directory or photo file,
+	// OldPath is the previous one for SyncRename.
+	Path, OldPath string
+
+	Err error
+}
+
+// SyncStats counts the photos handled by Mirror.Sync.
+type SyncStats struct {
+	Added, Updated, Renamed, Deleted, Unchanged, Failed int
+
+	// AlbumsFailed is the number of albums whose photos could not be listed.
+	AlbumsFailed int
+
+	// Bytes is the size of the downloaded files.
+	Bytes int64
+}
+
+// A Mirror keeps a local directory in sync with the albums of a user.
+//
+// The state is recorded in a Manifest, so only new and changed photos are
+// downloaded, renamed albums and photos are moved, and an interrupted Sync
+// continues where it stopped.
+type Mirror struct {
+	Client *Client
+
+	// UserID is the owner of the albums, "default" if empty.
+	UserID string
+
+	// Dir is the root of the mirror.
+	Dir string
+
+	// ManifestPath is the manifest file, Dir/ManifestName by default.
+	ManifestPath string
+
+	// Prune deletes the local files of remotely deleted albums and photos.
+	// Otherwise they are only removed from the manifest.
+	Prune bool
+
+	// XMP makes Sync write XMP sidecars, too (see WriteXMPSidecar).
+	XMP bool
+
+	// EmbedMetadata makes Sync embed the metadata into the downloaded
+	// JPEGs (see Downloader.EmbedMetadata).
+	EmbedMetadata bool
+
+	// TimeOrder, if not empty, is the priority of the timestamps to set
+	// the photos' and the album directories' modification times from.
+	TimeOrder []TimeSource
+
+	// Dedupe, if not nil, stores each distinct photo only once
+	// (see Downloader.Dedupe).
+	Dedupe *Deduper
+
+	// Filter, if not nil, selects the albums and photos to mirror.
+	// The local copies of the others are left alone.
+	Filter *Filter
+
+	// Concurrency, Retries, Selector and Log configure the Downloader.
+	Concurrency, Retries int
+	Selector             MediaSelector
+	Log                  func(...interface{}) error
+
+	// OnEvent, if not nil, is called for every change.
+	OnEvent func(SyncEvent)
+}
+
+// Sync brings the mirror up-to-date. Failed downloads and albums whose
+// photos could not be listed are reported through OnEvent and counted in
+// the returned SyncStats, but don't stop the Sync.
+func (m *Mirror) Sync(ctx context.Context) (stats SyncStats, err error) {
+	manifestPath := m.manifestPath()
+	if err = os.MkdirAll(m.Dir, 0750); err != nil {
+		return stats, err
+	}
+	man, err := LoadManifest(manifestPath)
+	if err != nil {
+		return stats, err
+	}
+	defer func() {
+		if saveErr := man.Save(manifestPath); saveErr != nil && err == nil {
+			err = saveErr
+		}
+	}()
+
+	albums, err := m.Client.GetAlbums(m.UserID)
+	if err != nil {
+		return stats, err
+	}
+	seen := make(map[string]bool, len(albums))
+	for _, a := range albums {
+		if err = ctx.Err(); err != nil {
+			return stats, err
+		}
+		seen[a.ID] = true
+		if m.Filter != nil && !m.Filter.Album(a) {
+			continue
+		}
+		if err = m.syncAlbum(ctx, man, a, &stats); err != nil {
+			return stats, err
+		}
+		if err = man.Save(manifestPath); err != nil {
+			return stats, err
+		}
+	}
+	for id, ma := range man.Albums {
+		if seen[id] {
+			continue
+		}
+		for pid := range ma.Photos {
+			m.deletePhoto(ma, pid, &stats)
+		}
+		if m.Prune {
+			os.Remove(m.abs(ma.Dir + "/" + AlbumSidecarName))
+			os.Remove(m.abs(ma.Dir + "/" + BlobIndexName))
+			os.Remove(m.abs(ma.Dir))
+		}
+		delete(man.Albums, id)
+		m.event(SyncEvent{Op: SyncDelete, AlbumID: id, Path: m.abs(ma.Dir)})
+	}
+	man.UserID, man.Synced = m.UserID, time.Now().UTC()
+	return stats, nil
+}
+
+func (m *Mirror) syncAlbum(ctx context.Context, man *Manifest, a Album, stats *SyncStats) error {
+	dir := AlbumDirName(a)
+	ma := man.Albums[a.ID]
+	if ma == nil {
+		ma = &MirroredAlbum{ID: a.ID, Dir: dir, Photos: make(map[string]*MirroredPhoto)}
+		man.Albums[a.ID] = ma
+		m.event(SyncEvent{Op: SyncAdd, AlbumID: a.ID, Path: m.abs(dir)})
+	} else if ma.Dir != dir {
+		if err := m.move(ma.Dir, dir); err != nil {
+			return err
+		}
+		for _, mp := range ma.Photos {
+			mp.Path = dir + "/" + filepath.Base(mp.Path)
+		}
+		m.event(SyncEvent{Op: SyncRename, AlbumID: a.ID, Path: m.abs(dir), OldPath: m.abs(ma.Dir)})
+		ma.Dir = dir
+	}
+	if m.unchanged(ma, a) {
+		stats.Unchanged += len(ma.Photos)
+		m.event(SyncEvent{Op: SyncSkip, AlbumID: a.ID, Path: m.abs(dir)})
+		return nil
+	}
+	ma.Complete = false
+
+	if err := os.MkdirAll(m.abs(dir), 0750); err != nil {
+		return err
+	}
+	if err := WriteAlbumSidecar(m.abs(dir), a); err != nil {
+		return err
+	}
+	ma.Name, ma.Title = a.Name, a.Title
+
+	photos, err := m.Client.GetPhotos(m.UserID, a.ID)
+	if err != nil {
+		// Leave the album incomplete, so the next Sync retries it.
+		stats.AlbumsFailed++
+		m.event(SyncEvent{Op: SyncUpdate, AlbumID: a.ID, Path: m.abs(dir), Err: err})
+		return nil
+	}
+
+	names := PhotoFileNames(photos)
+	seen := make(map[string]bool, len(photos))
+	selected := make([]Photo, 0, len(photos))
+	for _, p := range photos {
+		seen[p.ID] = true
+		if m.Filter == nil || m.Filter.Photo(a, p) {
+			selected = append(selected, p)
+		}
+	}
+	// Delete first, so no photo is renamed onto a file deleted after.
+	for _, pid := range ma.photoIDs() {
+		if !seen[pid] {
+			m.deletePhoto(ma, pid, stats)
+		}
+	}
+	if err = m.renamePhotos(ma, selected, names, stats); err != nil {
+		return err
+	}
+
+	ops := make(map[string]SyncOp, len(selected))
+	var jobs []DownloadJob
+	for _, p := range selected {
+		path := dir + "/" + names[p.ID]
+		mp := ma.Photos[p.ID]
+		changed := mp != nil && (mp.ETag != p.ETag || !mp.Updated.Equal(p.Updated))
+		if mp != nil && mp.Complete && !changed {
+			if m.exists(path) {
+				stats.Unchanged++
+				continue
+			}
+		}
+		ops[p.ID] = SyncAdd
+		if mp != nil {
+			ops[p.ID] = SyncUpdate
+		}
+		if changed {
+			// Don't resume the download of a previous version.
+			for _, fn := range []string{m.abs(path), m.abs(path) + ".part"} {
+				os.Remove(fn)
+				os.Remove(fn + validatorExt)
+			}
+			if m.Dedupe != nil {
+				m.Dedupe.Remove(m.abs(path))
+			}
+		}
+		if err = WritePhotoSidecar(m.abs(path), p); err != nil {
+			return err
+		}
+		if m.XMP {
+			if err = WriteXMPSidecar(m.abs(path), p); err != nil {
+				return err
+			}
+		}
+		ma.Photos[p.ID] = &MirroredPhoto{ID: p.ID, ETag: p.ETag, Updated: p.Updated, Path: path}
+		jobs = append(jobs, DownloadJob{Album: a, Photo: p, Changed: changed})
+	}
+
+	dl := m.downloader(DestinationFunc(func(_ Album, p Photo) (string, error) {
+		return m.abs(dir + "/" + names[p.ID]), nil
+	}))
+	complete := true
+	for _, res := range dl.Download(ctx, jobs) {
+		op := ops[res.Photo.ID]
+		if res.Err != nil {
+			complete = false
+			stats.Failed++
+			m.event(SyncEvent{Op: op, AlbumID: a.ID, PhotoID: res.Photo.ID, Path: res.Path, Err: res.Err})
+			continue
+		}
+		mp := ma.Photos[res.Photo.ID]
+		mp.Complete, mp.Size = true, res.Photo.Size
+		if res.Download != nil {
+			mp.Size = res.Download.Size
+			stats.Bytes += res.Download.Size - res.Download.Offset
+		}
+		if op == SyncAdd {
+			stats.Added++
+		} else {
+			stats.Updated++
+		}
+		m.event(SyncEvent{Op: op, AlbumID: a.ID, PhotoID: res.Photo.ID, Path: res.Path})
+	}
+	for _, mp := range ma.Photos {
+		complete = complete && mp.Complete
+	}
+	ma.ETag, ma.Updated, ma.Complete = a.ETag, a.Updated, complete
+	ma.Selection = m.selection()
+	if len(m.TimeOrder) != 0 {
+		if err = SetAlbumTime(m.abs(dir), a, m.TimeOrder); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// unchanged reports whether the album has been mirrored completely, as it
+// is now, with the current photo selection.
+func (m *Mirror) unchanged(ma *MirroredAlbum, a Album) bool {
+	return ma.Complete && ma.ETag == a.ETag && ma.Updated.Equal(a.Updated) && ma.Selection == m.selection()
+}
+
+// selection returns the PhotoKey of the Filter.
+func (m *Mirror) selection() string {
+	if m.Filter == nil {
+		return ""
+	}
+	return m.Filter.PhotoKey()
+}
+
+// downloader returns the Downloader of the photos.
+func (m *Mirror) downloader(dest Destination) *Downloader {
+	return &Downloader{
+		Client:        m.Client,
+		Destination:   dest,
+		Concurrency:   m.Concurrency,
+		Retries:       m.Retries,
+		Selector:      m.Selector,
+		EmbedMetadata: m.EmbedMetadata,
+		TimeOrder:     m.TimeOrder,
+		Dedupe:        m.Dedupe,
+		Log:           m.Log,
+	}
+}
+
+func (m *Mirror) deletePhoto(ma *MirroredAlbum, id string, stats *SyncStats) {
+	mp := ma.Photos[id]
+	delete(ma.Photos, id)
+	var err error
+	if m.Prune && !ma.owned(mp.Path) {
+		if err = os.Remove(m.abs(mp.Path)); os.IsNotExist(err) {
+			err = nil
+		}
+		for _, ext := range sidecarExts {
+			os.Remove(m.abs(mp.Path) + ext)
+		}
+		if m.Dedupe != nil && err == nil {
+			err = m.Dedupe.Remove(m.abs(mp.Path))
+		}
+	}
+	stats.Deleted++
+	m.event(SyncEvent{Op: SyncDelete, AlbumID: ma.ID, PhotoID: id, Path: m.abs(mp.Path), Err: err})
+}
+
+// owned reports whether a photo of the album is at path.
+func (ma *MirroredAlbum) owned(path string) bool {
+	for _, mp := range ma.Photos {
+		if mp.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// renamePhotos moves the mirrored photos to their new names. The moves go
+// through temporary names, so photos taking each other's names don't
+// overwrite each other.
+func (m *Mirror) renamePhotos(ma *MirroredAlbum, photos []Photo, names map[string]string, stats *SyncStats) error {
+	type rename struct {
+		mp      *MirroredPhoto
+		tmp, to string
+	}
+	var renames []rename
+	for _, p := range photos {
+		mp, to := ma.Photos[p.ID], ma.Dir+"/"+names[p.ID]
+		if mp == nil || mp.Path == to {
+			continue
+		}
+		r := rename{mp: mp, tmp: ma.Dir + "/.picago-rename-" + SanitiseName(p.ID), to: to}
+		if err := m.movePhoto(mp.Path, r.tmp); err != nil {
+			return err
+		}
+		renames = append(renames, r)
+	}
+	for _, r := range renames {
+		if err := m.movePhoto(r.tmp, r.to); err != nil {
+			return err
+		}
+		stats.Renamed++
+		m.event(SyncEvent{Op: SyncRename, AlbumID: ma.ID, PhotoID: r.mp.ID, Path: m.abs(r.to), OldPath: m.abs(r.mp.Path)})
+		r.mp.Path = r.to
+	}
+	return nil
+}
+
+// movePhoto moves the photo, its index entry and sidecars (relative to the root).
+func (m *Mirror) movePhoto(from, to string) error {
+	if err := m.move(from, to); err != nil {
+		return err
+	}
+	if m.Dedupe != nil {
+		if err := m.Dedupe.Rename(m.abs(from), m.abs(to)); err != nil {
+			return err
+		}
+	}
+	for _, ext := range sidecarExts {
+		if err := m.move(from+ext, to+ext); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exists reports whether the photo at path (relative to the root) is there.
+func (m *Mirror) exists(path string) bool {
+	if m.Dedupe != nil {
+		return m.Dedupe.Exists(m.abs(path))
+	}
+	_, err := os.Stat(m.abs(path))
+	return err == nil
+}
+
+func (m *Mirror) manifestPath() string {
+	if m.ManifestPath != "" {
+		return m.ManifestPath
+	}
+	return filepath.Join(m.Dir, ManifestName)
+}
+
+func (m *Mirror) abs(rel string) string { return filepath.Join(m.Dir, filepath.FromSlash(rel)) }
+
+// move renames from to to (both relative to the root), if from exists.
+func (m *Mirror) move(from, to string) error {
+	if _, err := os.Lstat(m.abs(from)); os.IsNotExist(err) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(m.abs(to)), 0750); err != nil {
+		return err
+	}
+	return os.Rename(m.abs(from), m.abs(to))
+}
+
+func (m *Mirror) event(e SyncEvent) {
+	if m.OnEvent != nil {
+		m.OnEvent(e)
+	}
+}
@@ -0,0 +1,323 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package picago
+
+import (
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePicasa serves album and photo feeds and the photos themselves.
+type fakePicasa struct {
+	mu       sync.Mutex
+	albums   []Album
+	photos   map[string][]Photo
+	content  map[string]string
+	requests []string
+}
+
+func (f *fakePicasa) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r.URL.Path)
+	if s, ok := f.content[r.URL.Path]; ok {
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(s))
+		return
+	}
+	var feed Atom
+	if r.URL.Query().Get("start-index") == "1" {
+		if i := strings.Index(r.URL.Path, "/albumid/"); i >= 0 {
+			for _, p := range f.photos[r.URL.Path[i+len("/albumid/"):]] {
+				feed.Entries = append(feed.Entries, *p.Entry())
+			}
+		} else {
+			for _, a := range f.albums {
+				feed.Entries = append(feed.Entries, *a.Entry())
+			}
+		}
+	}
+	if err := xml.NewEncoder(w).Encode(feed); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (f *fakePicasa) photo(albumID, id, filename, etag, content string) Photo {
+	f.content["/"+id] = content
+	return Photo{ID: id, AlbumID: albumID, Filename: filename, ETag: etag,
+		Media: []MediaContent{{URL: "https://lh3.example.com/" + id, Type: "image/jpeg", Medium: "image", Width: 1, Height: 1}},
+	}
+}
+
+// rewriteTransport sends every request to the test server.
+type rewriteTransport struct{ host string }
+
+func (t rewriteTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r2 := *r
+	u := *r.URL
+	u.Scheme, u.Host = "http", t.host
+	r2.URL = &u
+	return http.DefaultTransport.RoundTrip(&r2)
+}
+
+func TestMirror(t *testing.T) {
+	fake := &fakePicasa{photos: make(map[string][]Photo), content: make(map[string]string)}
+	fake.albums = []Album{
+		{ID: "1", Name: "Summer", Title: "Summer", ETag: "a1"},
+		{ID: "2", Name: "Winter", Title: "Winter", ETag: "a2"},
+	}
+	fake.photos["1"] = []Photo{
+		fake.photo("1", "11", "a.jpg", "p1", "aaa"),
+		fake.photo("1", "12", "b.jpg", "p1", "bbb"),
+	}
+	fake.photos["2"] = []Photo{
+		fake.photo("2", "22", "C.JPG", "p1", "CCC"),
+		fake.photo("2", "21", "c.jpg", "p1", "ccc"),
+	}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "picago-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := Mirror{
+		Client: &Client{Client: &http.Client{Transport: rewriteTransport{host: srv.Listener.Addr().String()}}},
+		Dir:    dir,
+		Prune:  true,
+	}
+	check := func(want SyncStats) {
+		t.Helper()
+		stats, err := m.Sync(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats != want {
+			t.Errorf("got %+v, wanted %+v", stats, want)
+		}
+	}
+	wantFiles := func(want map[string]string) {
+		t.Helper()
+		for path, content := range want {
+			b, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(path)))
+			if err != nil {
+				t.Error(err)
+				continue
+			}
+			if string(b) != content {
+				t.Errorf("%s: got %q, wanted %q", path, b, content)
+			}
+		}
+	}
+
+	check(SyncStats{Added: 4, Bytes: 12})
+	wantFiles(map[string]string{
+		"Summer [1]/a.jpg": "aaa", "Summer [1]/b.jpg": "bbb",
+		"Winter [2]/c.jpg": "ccc", "Winter [2]/C_22.JPG": "CCC",
+	})
+
+	// Nothing changed: the photo feeds are not even fetched.
+	fake.requests = fake.requests[:0]
+	check(SyncStats{Unchanged: 4})
+	for _, path := range fake.requests {
+		if strings.Contains(path, "/albumid/") {
+			t.Errorf("unchanged album fetched: %s", path)
+		}
+	}
+
+	// Rename an album, change, rename and delete photos.
+	fake.albums[0].Title, fake.albums[0].ETag = "Summer 2014", "a1b"
+	fake.photos["1"] = []Photo{
+		fake.photo("1", "11", "a.jpg", "p2", "AAAA"),
+		fake.photo("1", "12", "bb.jpg", "p1", "bbb"),
+	}
+	fake.albums = fake.albums[:1]
+	check(SyncStats{Updated: 1, Renamed: 1, Deleted: 2, Unchanged: 1, Bytes: 4})
+	wantFiles(map[string]string{"Summer 2014 [1]/a.jpg": "AAAA", "Summer 2014 [1]/bb.jpg": "bbb"})
+	for _, path := range []string{"Summer [1]", "Winter [2]", "Summer 2014 [1]/b.jpg"} {
+		if _, err := os.Stat(filepath.Join(dir, path)); !os.IsNotExist(err) {
+			t.Errorf("%s: should not exist (%v)", path, err)
+		}
+	}
+
+	man, err := LoadManifest(filepath.Join(dir, ManifestName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(man.Albums) != 1 || man.Albums["1"].Dir != "Summer 2014 [1]" || len(man.Albums["1"].Photos) != 2 {
+		t.Errorf("bad manifest: %+v", man.Albums)
+	}
+}
+
+func TestMirrorAlbumFailed(t *testing.T) {
+	fake := &fakePicasa{photos: make(map[string][]Photo), content: make(map[string]string)}
+	fake.albums = []Album{
+		{ID: "1", Name: "Summer", Title: "Summer", ETag: "a1"},
+		{ID: "2", Name: "Winter", Title: "Winter", ETag: "a2"},
+	}
+	fake.photos["1"] = []Photo{fake.photo("1", "11", "a.jpg", "p1", "aaa")}
+	fake.photos["2"] = []Photo{fake.photo("2", "21", "b.jpg", "p1", "bbb")}
+	failing := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing && strings.HasSuffix(r.URL.Path, "/albumid/2") {
+			http.Error(w, "oops", http.StatusInternalServerError)
+			return
+		}
+		fake.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "picago-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := Mirror{
+		Client: &Client{Client: &http.Client{Transport: rewriteTransport{host: srv.Listener.Addr().String()}}},
+		Dir:    dir,
+	}
+	stats, err := m.Sync(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (SyncStats{Added: 1, AlbumsFailed: 1, Bytes: 3}); stats != want {
+		t.Errorf("got %+v, wanted %+v", stats, want)
+	}
+
+	// The failed album is retried.
+	failing = false
+	if stats, err = m.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if want := (SyncStats{Added: 1, Unchanged: 1, Bytes: 3}); stats != want {
+		t.Errorf("got %+v, wanted %+v", stats, want)
+	}
+}
+
+func TestMirrorRenameDelete(t *testing.T) {
+	fake := &fakePicasa{photos: make(map[string][]Photo), content: make(map[string]string)}
+	fake.albums = []Album{{ID: "1", Name: "Summer", Title: "Summer", ETag: "a1"}}
+	fake.photos["1"] = []Photo{
+		fake.photo("1", "21", "c.jpg", "p1", "old"),
+		fake.photo("1", "22", "c.jpg", "p1", "new"),
+		fake.photo("1", "31", "x.jpg", "p1", "xxx"),
+		fake.photo("1", "32", "y.jpg", "p1", "yyy"),
+	}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "picago-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := Mirror{
+		Client: &Client{Client: &http.Client{Transport: rewriteTransport{host: srv.Listener.Addr().String()}}},
+		Dir:    dir,
+		Prune:  true,
+	}
+	if _, err = m.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// 21 is deleted, so 22 takes its name; 31 and 32 swap names.
+	fake.photos["1"] = []Photo{
+		fake.photo("1", "22", "c.jpg", "p1", "new"),
+		fake.photo("1", "31", "y.jpg", "p1", "xxx"),
+		fake.photo("1", "32", "x.jpg", "p1", "yyy"),
+	}
+	fake.albums[0].ETag = "a2"
+	stats, err := m.Sync(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (SyncStats{Renamed: 3, Deleted: 1, Unchanged: 3}); stats != want {
+		t.Errorf("got %+v, wanted %+v", stats, want)
+	}
+	for name, want := range map[string]string{"c.jpg": "new", "x.jpg": "yyy", "y.jpg": "xxx"} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, AlbumDirName(fake.albums[0]), name))
+		if err != nil || string(b) != want {
+			t.Errorf("%s: got %q (%v), wanted %q", name, b, err, want)
+		}
+	}
+	fis, err := ioutil.ReadDir(filepath.Join(dir, AlbumDirName(fake.albums[0])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fi := range fis {
+		if strings.HasPrefix(fi.Name(), ".picago-rename-") {
+			t.Errorf("temporary file left: %s", fi.Name())
+		}
+	}
+}
+
+func TestPhotoFileNames(t *testing.T) {
+	photos := []Photo{
+		{ID: "30", Filename: "IMG_0001.JPG"},
+		{ID: "4", Filename: "img_0001.jpg"},
+		{ID: "100", Filename: "IMG_0001.JPG"},
+		{ID: "5", Filename: "a/b.jpg"},
+		{ID: "6"},
+	}
+	want := map[string]string{
+		"4": "img_0001.jpg", "30": "IMG_0001_30.JPG", "100": "IMG_0001_100.JPG",
+		"5": "a_b.jpg", "6": "6",
+	}
+	for i := 0; i < 2; i++ {
+		got := PhotoFileNames(photos)
+		for id, name := range want {
+			if got[id] != name {
+				t.Errorf("%s: got %q, wanted %q", id, got[id], name)
+			}
+		}
+		// The order of the photos must not matter.
+		photos[0], photos[2] = photos[2], photos[0]
+	}
+}
+
+func TestLayoutRename(t *testing.T) {
+	dir, err := ioutil.TempDir("", "picago-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	l := NewLayout(dir)
+	a := Album{ID: "42", Title: "Old"}
+	p := Photo{ID: "1", Filename: "a.jpg"}
+	if _, err = l.AddAlbum(a, []Photo{p}); err != nil {
+		t.Fatal(err)
+	}
+	path, _ := l.Path(a, p)
+	if err = ioutil.WriteFile(path, []byte("a"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	a.Title = "New"
+	got, err := l.AddAlbum(a, []Photo{p})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "New [42]"); got != want {
+		t.Errorf("got %q, wanted %q", got, want)
+	}
+	if _, err = os.Stat(filepath.Join(got, "a.jpg")); err != nil {
+		t.Error(err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "Old [42]")); !os.IsNotExist(err) {
+		t.Errorf("old directory remained: %v", err)
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package picago
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// PathData is the data a PathTemplate is executed with.
+type PathData struct {
+	Album Album
+	Photo Photo
+
+	// Exif is Photo.Exif.
+	Exif Exif
+
+	// Name is the photo's file name, unique within the album
+	// (see PhotoFileNames).
+	Name string
+
+	// Taken is the time the photo was taken: Exif's time, or the
+	// server's timestamp, or the time of publishing.
+	Taken time.Time
+}
+
+// PathFuncs are the functions usable in a PathTemplate, besides the
+// text/template builtins:
+//
+//	clean  makes a string usable as a single path element (SanitiseName): {{clean .Album.Title}}
+//	date   formats a time with a Go layout: {{date "2006/01" .Taken}}
+//	def    returns its first argument if the second is empty: {{def "unknown" .Exif.Model}}
+//	ext    returns the extension of a file name, with the dot
+//	base   returns a file name without its extension
+//	lower  and upper change the case of a string
+var PathFuncs = template.FuncMap{
+	"clean": SanitiseName,
+	"date": func(layout string, t time.Time) string {
+		return t.Format(layout)
+	},
+	"def": func(def, s string) string {
+		if s == "" {
+			return def
+		}
+		return s
+	},
+	"ext":   filepath.Ext,
+	"base":  func(s string) string { return strings.TrimSuffix(s, filepath.Ext(s)) },
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+// A PathTemplate is a Destination which renders the path of a photo
+// from a text/template, executed with a PathData.
+// The result is slash-separated and relative to Dir, with each element
+// made safe by SanitiseName.
+//
+// Register the albums with AddAlbum before downloading their photos,
+// so that PathData.Name is unique within the album. If more registered
+// photos (e.g. of different albums) are rendered to the same path, the
+// one with the smallest ID keeps it, the others get their ID appended,
+// as by PhotoFileNames. So register all the albums first for the paths
+// not to depend on the order of the albums.
+type PathTemplate struct {
+	Dir string
+
+	tmpl  *template.Template
+	names photoNames
+
+	mu     sync.Mutex
+	claims map[string][]pathClaim // the photos rendered to a folded path
+	paths  map[string]string      // by album and photo ID
+	used   map[string]bool        // by folded path
+}
+
+// A pathClaim is a photo rendered to a path.
+type pathClaim struct{ albumID, photoID string }
+
+func (c pathClaim) less(d pathClaim) bool {
+	if c.photoID != d.photoID {
+		return lessID(c.photoID, d.photoID)
+	}
+	return c.albumID < d.albumID
+}
+
+// NewPathTemplate parses text, and checks it by executing it with
+// sample data, so an invalid template fails before any download.
+func NewPathTemplate(dir, text string) (*PathTemplate, error) {
+	tmpl, err := template.New("path").Funcs(PathFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	pt := &PathTemplate{Dir: dir, tmpl: tmpl}
+	sample := Photo{
+		ID: "2", AlbumID: "1", Filename: "photo.jpg", Type: "image/jpeg",
+		Published: time.Now(),
+		Exif:      Exif{Make: "make", Model: "model"},
+	}
+	if _, err = pt.render(Album{ID: "1", Name: "album", Title: "album"}, sample); err != nil {
+		return nil, fmt.Errorf("checking path template: %v", err)
+	}
+	return pt, nil
+}
+
+// AddAlbum registers the photos of the album.
+func (pt *PathTemplate) AddAlbum(a Album, photos []Photo) {
+	pt.names.set(a.ID, photos)
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	for _, p := range photos {
+		// The errors are returned by Render.
+		if fn, err := pt.render(a, p); err == nil {
+			pt.claim(fn, pathClaim{albumID: a.ID, photoID: p.ID})
+		}
+	}
+}
+
+// claim records that the photo is rendered to fn. pt.mu must be held.
+func (pt *PathTemplate) claim(fn string, c pathClaim) {
+	if pt.claims == nil {
+		pt.claims = make(map[string][]pathClaim)
+	}
+	k := foldName(fn)
+	for _, d := range pt.claims[k] {
+		if d == c {
+			return
+		}
+	}
+	pt.claims[k] = append(pt.claims[k], c)
+}
+
+// first reports whether c is the smallest of the claims of fn.
+// pt.mu must be held.
+func (pt *PathTemplate) first(fn string, c pathClaim) bool {
+	for _, d := range pt.claims[foldName(fn)] {
+		if d.less(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// Path returns the path of the photo, implementing Destination, and
+// creates its directory.
+// It is an error if the rendered path is empty or points outside of Dir.
+func (pt *PathTemplate) Path(a Album, p Photo) (string, error) {
+	fn, err := pt.Render(a, p)
+	if err != nil {
+		return "", err
+	}
+	return fn, os.MkdirAll(filepath.Dir(fn), 0750)
+}
+
+// Render returns the path of the photo, without creating anything,
+// implementing PathRenderer. The path is reserved for the photo: the same
+// photo gets the same path later, other photos rendered to it another one.
+func (pt *PathTemplate) Render(a Album, p Photo) (string, error) {
+	key := a.ID + "/" + p.ID
+	pt.mu.Lock()
+	fn, ok := pt.paths[key]
+	pt.mu.Unlock()
+	if ok {
+		return fn, nil
+	}
+	fn, err := pt.render(a, p)
+	if err != nil {
+		return "", err
+	}
+
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	if old, ok := pt.paths[key]; ok {
+		return old, nil
+	}
+	if pt.paths == nil {
+		pt.paths, pt.used = make(map[string]string), make(map[string]bool)
+	}
+	c := pathClaim{albumID: a.ID, photoID: p.ID}
+	pt.claim(fn, c)
+	if pt.used[foldName(fn)] || !pt.first(fn, c) {
+		dir, name := filepath.Split(fn)
+		ext := filepath.Ext(name)
+		base, suffix := name[:len(name)-len(ext)], "_"+SanitiseName(p.ID)
+		fn = dir + withSuffix(base, suffix, ext)
+		for i := 2; pt.used[foldName(fn)]; i++ {
+			fn = dir + withSuffix(base, suffix+"_"+strconv.Itoa(i), ext)
+		}
+	}
+	pt.used[foldName(fn)] = true
+	pt.paths[key] = fn
+	return fn, nil
+}
+
+func (pt *PathTemplate) render(a Album, p Photo) (string, error) {
+	data := PathData{Album: a, Photo: p, Exif: p.Exif, Name: pt.names.get(a.ID, p), Taken: p.taken()}
+	var buf bytes.Buffer
+	if err := pt.tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	rel := path.Clean(strings.TrimSpace(buf.String()))
+	if rel == "." || strings.HasSuffix(buf.String(), "/") {
+		return "", fmt.Errorf("path template gave no file name for photo %s: %q", p.ID, buf.String())
+	}
+	if path.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("path template gave %q for photo %s, outside of the directory", rel, p.ID)
+	}
+	elems := strings.Split(rel, "/")
+	for i, e := range elems {
+		elems[i] = SanitiseName(e)
+	}
+	return filepath.Join(pt.Dir, filepath.Join(elems...)), nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package picago
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPathTemplate(t *testing.T) {
+	for _, text := range []string{
+		"{{.Album.Nope}}",
+		"{{",
+		"",
+		"../{{.Name}}",
+		"/etc/{{.Name}}",
+		"{{.Album.Title}}/",
+	} {
+		if _, err := NewPathTemplate("root", text); err == nil {
+			t.Errorf("%q: no error", text)
+		}
+	}
+
+	pt, err := NewPathTemplate("root", `{{date "2006/01" .Taken}}/{{clean .Album.Title}}/{{def "unknown" .Exif.Model | lower}}-{{.Name}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Date(2014, 7, 21, 12, 0, 0, 0, time.UTC).UnixNano() / int64(time.Millisecond)
+	a := Album{ID: "1", Title: "Biking / Hiking"}
+	photos := []Photo{
+		{ID: "2", Filename: "a.jpg", Exif: Exif{Model: "X100", Timestamp: &ts}},
+		{ID: "3", Filename: "A.JPG", Published: time.Date(2015, 1, 2, 0, 0, 0, 0, time.UTC)},
+	}
+	pt.AddAlbum(a, photos)
+	for i, want := range []string{
+		"root/2014/07/Biking _ Hiking/x100-a.jpg",
+		"root/2015/01/Biking _ Hiking/unknown-A_3.JPG",
+	} {
+		got, err := pt.Render(a, photos[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != filepath.FromSlash(want) {
+			t.Errorf("%d. got %q, wanted %q", i, got, want)
+		}
+	}
+
+	// Photos of another album, rendered to the same path.
+	b := Album{ID: "4", Title: "Biking / Hiking"}
+	other := Photo{ID: "5", Filename: "a.jpg", Exif: Exif{Model: "X100", Timestamp: &ts}}
+	pt.AddAlbum(b, []Photo{other})
+	for i := 0; i < 2; i++ {
+		got, err := pt.Render(b, other)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := filepath.FromSlash("root/2014/07/Biking _ Hiking/x100-a_5.jpg"); got != want {
+			t.Errorf("got %q, wanted %q", got, want)
+		}
+	}
+	if got, _ := pt.Render(a, photos[0]); got != filepath.FromSlash("root/2014/07/Biking _ Hiking/x100-a.jpg") {
+		t.Errorf("the path of the first photo changed to %q", got)
+	}
+
+	// The smallest ID keeps the path, whichever photo is rendered first.
+	for _, order := range [][2]int{{0, 1}, {1, 0}} {
+		pt, err := NewPathTemplate("root", `{{date "2006" .Taken}}/{{.Photo.Filename}}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		albums := []Album{{ID: "1"}, {ID: "2"}}
+		photos := []Photo{
+			{ID: "12", Filename: "IMG_1.jpg", Exif: Exif{Timestamp: &ts}},
+			{ID: "11", Filename: "img_1.jpg", Exif: Exif{Timestamp: &ts}},
+		}
+		for i := range albums {
+			pt.AddAlbum(albums[i], photos[i:i+1])
+		}
+		want := []string{"root/2014/IMG_1_12.jpg", "root/2014/img_1.jpg"}
+		for _, i := range order {
+			got, err := pt.Render(albums[i], photos[i])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != filepath.FromSlash(want[i]) {
+				t.Errorf("%v: %s got %q, wanted %q", order, photos[i].ID, got, want[i])
+			}
+		}
+	}
+
+	// Every element is sanitised, not only the clean ones.
+	pt, err = NewPathTemplate("root", `{{.Album.Title}}/{{.Photo.Filename}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := pt.Render(Album{ID: "1", Title: "a:b "}, Photo{ID: "2", Filename: "CON.jpg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.FromSlash("root/a_b/_CON.jpg"); got != want {
+		t.Errorf("got %q, wanted %q", got, want)
+	}
+}
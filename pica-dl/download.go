@@ -0,0 +1,298 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/tgulacsi/picago"
+)
+
+// blobDirName is the directory of the -dedupe store, under -dir.
+const blobDirName = ".picago-blobs"
+
+// blobDirOf returns the -dedupe store of the mirror in dir, if it exists.
+func blobDirOf(dir string) string {
+	blobDir := filepath.Join(dir, blobDirName)
+	if fi, err := os.Stat(blobDir); err == nil && fi.IsDir() {
+		return blobDir
+	}
+	return ""
+}
+
+func runDownload(cmd *command, args []string) int {
+	fs, cf := cmd.flagSet()
+	var ff filterFlags
+	ff.register(fs)
+	flagDir := fs.String("dir", "", "directory to download images to (required)")
+	flagConcurrency := fs.Int("concurrency", 4, "number of parallel downloads")
+	flagRetries := fs.Int("retries", 3, "number of retries of a failed download")
+	flagPath := fs.String("path", "", `text/template of the photos' paths under dir, e.g. {{date "2006/01" .Taken}}/{{clean .Album.Title}}/{{.Name}}
+(fields: .Album, .Photo, .Exif, .Name, .Taken; funcs: clean, date, def, ext, base, lower, upper)`)
+	flagXMP := fs.Bool("xmp", false, "write XMP sidecars (photo.jpg.xmp) next to the photos")
+	flagEmbed := fs.Bool("embed", false, "embed the caption, keywords and position into the downloaded JPEGs")
+	flagTimes := fs.String("times", "exif,timestamp,published", "set the files' times from the first available of these (exif, timestamp, published, updated); empty to leave them")
+	flagSync := fs.Bool("sync", false, "incrementally mirror the albums into dir, keeping a manifest there")
+	flagPrune := fs.Bool("prune", false, "with -sync, delete the local copies of deleted albums and photos")
+	flagDedupe := fs.String("dedupe", "", "store each distinct photo once in dir/"+blobDirName+", and put hardlink, symlink or blobs (per-album "+picago.BlobIndexName+" only) to the albums")
+	flagPlan := fs.Bool("plan", false, "only print what would be added, updated, renamed, deleted and skipped, with the byte totals, without writing anything")
+	flagJSON := fs.Bool("json", false, "with -plan, print the whole plan as JSON")
+	if err := fs.Parse(args); err != nil {
+		return parseExit(err)
+	}
+	if fs.NArg() > 1 {
+		return badUsage(fs, "unexpected arguments %q", fs.Args()[1:])
+	} else if fs.NArg() == 1 && cf.User == "" {
+		// The user ID was the argument in the old versions.
+		cf.User = fs.Arg(0)
+	}
+	if *flagDir == "" {
+		return badUsage(fs, "-dir is required")
+	}
+
+	timeOrder, err := picago.ParseTimeOrder(*flagTimes)
+	if err != nil {
+		return badUsage(fs, "bad -times: %v", err)
+	}
+	filter, err := ff.filter()
+	if err != nil {
+		return badUsage(fs, "%v", err)
+	}
+	var pathTmpl *picago.PathTemplate
+	if *flagPath != "" {
+		if *flagSync {
+			return badUsage(fs, "-path cannot be used with -sync")
+		}
+		if pathTmpl, err = picago.NewPathTemplate(*flagDir, *flagPath); err != nil {
+			return badUsage(fs, "bad -path: %v", err)
+		}
+	}
+	var dedupeMode picago.DedupeMode
+	if *flagDedupe != "" {
+		if dedupeMode, err = picago.ParseDedupeMode(*flagDedupe); err != nil {
+			return badUsage(fs, "bad -dedupe: %v", err)
+		}
+	}
+
+	client, code := cf.authorize()
+	if code != exitOK {
+		return code
+	}
+
+	var dedupe *picago.Deduper
+	if dedupeMode != "" {
+		newDeduper := picago.NewDeduper
+		if *flagPlan {
+			newDeduper = picago.OpenDeduper
+		}
+		if dedupe, err = newDeduper(filepath.Join(*flagDir, blobDirName), dedupeMode); err != nil {
+			return fail("cannot open the blob store: %v", err)
+		}
+		defer dedupe.Close()
+	}
+
+	if *flagSync {
+		m := picago.Mirror{
+			Client:        client,
+			UserID:        cf.User,
+			Dir:           *flagDir,
+			Prune:         *flagPrune,
+			XMP:           *flagXMP,
+			EmbedMetadata: *flagEmbed,
+			TimeOrder:     timeOrder,
+			Dedupe:        dedupe,
+			Filter:        &filter,
+			Concurrency:   *flagConcurrency,
+			Retries:       *flagRetries,
+			Log:           logKeyvals,
+			OnEvent: func(e picago.SyncEvent) {
+				if e.Err != nil {
+					log.Printf("%s %s: %v", e.Op, e.Path, e.Err)
+				} else if e.Op == picago.SyncRename {
+					log.Printf("rename %s -> %s", e.OldPath, e.Path)
+				} else if cf.Verbose || e.Op != picago.SyncSkip {
+					log.Printf("%s %s", e.Op, e.Path)
+				}
+			},
+		}
+		if *flagPlan {
+			plan, err := m.Plan(context.Background())
+			if err != nil {
+				return fail("error planning the sync of %s: %v", *flagDir, err)
+			}
+			return printPlan(plan, *flagJSON, cf.Verbose)
+		}
+		stats, err := m.Sync(context.Background())
+		log.Printf("added=%d updated=%d renamed=%d deleted=%d unchanged=%d failed=%d albums_failed=%d bytes=%d",
+			stats.Added, stats.Updated, stats.Renamed, stats.Deleted, stats.Unchanged, stats.Failed, stats.AlbumsFailed, stats.Bytes)
+		if err != nil {
+			return fail("error syncing %s: %v", *flagDir, err)
+		}
+		if stats.Failed > 0 || stats.AlbumsFailed > 0 {
+			return exitFailure
+		}
+		return exitOK
+	}
+
+	albums, err := client.GetAlbums(cf.User)
+	if err != nil {
+		return fail("error listing albums: %v", err)
+	}
+	log.Printf("the user has %d albums.", len(albums))
+	albums = filter.Albums(albums)
+	log.Printf("%d albums selected.", len(albums))
+
+	layout := picago.NewLayout(*flagDir)
+	var dest picago.Destination = layout
+	if pathTmpl != nil {
+		dest = pathTmpl
+	}
+	dl := picago.Downloader{
+		Client:        client,
+		Destination:   dest,
+		Concurrency:   *flagConcurrency,
+		PerHost:       *flagConcurrency,
+		Retries:       *flagRetries,
+		Log:           logKeyvals,
+		EmbedMetadata: *flagEmbed,
+		TimeOrder:     timeOrder,
+		Dedupe:        dedupe,
+	}
+	if *flagPlan {
+		return printPlan(dl.PlanAlbums(cf.User, albums, filter), *flagJSON, cf.Verbose)
+	}
+	jobs := make(chan picago.DownloadJob)
+	done := make(chan int)
+	go func() {
+		var failed int
+		for res := range dl.Run(context.Background(), jobs) {
+			if res.Err != nil {
+				failed++
+				log.Printf("downloading %s: %v", res.Photo.URL, res.Err)
+				continue
+			}
+			if res.Deduped {
+				log.Printf("reused the stored copy of %s.", res.Path)
+				continue
+			}
+			log.Printf("downloaded %s (%d bytes).", res.Path, res.Download.Size)
+		}
+		done <- failed
+	}()
+	code = exitOK
+	listed := make(map[string][]picago.Photo)
+	if pathTmpl != nil {
+		// Photos of different albums may be rendered to the same path:
+		// register all the albums first, so which photo gets the path
+		// does not depend on the order of the albums.
+		for _, album := range albums {
+			if photos, err := client.GetPhotos(cf.User, album.ID); err == nil {
+				pathTmpl.AddAlbum(album, photos)
+				listed[album.ID] = photos
+			}
+		}
+	}
+	var dir, fn string
+	albumDirs := make(map[string]picago.Album)
+	dirOwners := make(map[string]string)
+Albums:
+	for _, album := range albums {
+		log.Printf("downloading album %s (%s).", album.ID, album.Title)
+		photos, ok := listed[album.ID]
+		var err error
+		if !ok {
+			photos, err = client.GetPhotos(cf.User, album.ID)
+		}
+		if err != nil {
+			log.Printf("error listing photos of %s: %v", album.ID, err)
+			code = exitFailure
+			continue
+		}
+		log.Printf("album %s contains %d photos.", album.ID, len(photos))
+		if pathTmpl != nil {
+			// The photos of an album may be scattered, so its metadata
+			// goes to the directories of its photos, below.
+			if !ok {
+				pathTmpl.AddAlbum(album, photos)
+			}
+		} else {
+			if dir, err = layout.AddAlbum(album, photos); err != nil {
+				code = fail("cannot create directory %s: %v", dir, err)
+				break
+			}
+			albumDirs[dir] = album
+			if err = picago.WriteAlbumSidecar(dir, album); err != nil {
+				code = fail("error writing the sidecar of %s: %v", dir, err)
+				break
+			}
+		}
+		// All the photos have been named above, so the names don't depend on the selection.
+		for _, photo := range filter.Photos(album, photos) {
+			if cf.Verbose {
+				photoJ, _ := json.Marshal(photo)
+				log.Printf("Photo: %s", photoJ)
+			}
+			if fn, err = dest.Path(album, photo); err != nil {
+				code = fail("error placing %s: %v", photo.ID, err)
+				break Albums
+			}
+			if pathTmpl != nil {
+				if err = writeTemplateSidecar(dirOwners, filepath.Dir(fn), album); err != nil {
+					code = fail("error writing the sidecar of %s: %v", filepath.Dir(fn), err)
+					break Albums
+				}
+			}
+			if err = picago.WritePhotoSidecar(fn, photo); err != nil {
+				code = fail("error writing %s.json: %v", fn, err)
+				break Albums
+			}
+			if *flagXMP {
+				if err = picago.WriteXMPSidecar(fn, photo); err != nil {
+					code = fail("error writing %s: %v", picago.XMPSidecarPath(fn), err)
+					break Albums
+				}
+			}
+			jobs <- picago.DownloadJob{Album: album, Photo: photo}
+		}
+	}
+	close(jobs)
+	failed := <-done
+	if len(timeOrder) != 0 {
+		// The directories' times change until all files are written.
+		for dir, album := range albumDirs {
+			if err := picago.SetAlbumTime(dir, album, timeOrder); err != nil {
+				log.Printf("setting the time of %s: %v", dir, err)
+			}
+		}
+	}
+	if failed > 0 {
+		return fail("%d downloads failed.", failed)
+	}
+	return code
+}
+
+// writeTemplateSidecar writes the sidecar of the album into dir, the
+// directory of one of its photos placed by -path, so it can be uploaded
+// (or restored) as the album. owners holds the album IDs of the
+// directories; a directory with the photos of more albums gets none.
+func writeTemplateSidecar(owners map[string]string, dir string, album picago.Album) error {
+	owner, ok := owners[dir]
+	switch {
+	case !ok:
+		owners[dir] = album.ID
+		return picago.WriteAlbumSidecar(dir, album)
+	case owner != "" && owner != album.ID:
+		owners[dir] = ""
+		log.Printf("%s holds the photos of more albums, so it gets no %s.", dir, picago.AlbumSidecarName)
+		if err := os.Remove(filepath.Join(dir, picago.AlbumSidecarName)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/tgulacsi/picago"
+)
+
+// commonFlags are the flags of all the commands.
+type commonFlags struct {
+	ID, Secret, Code, TokenCache, DebugDir, User string
+	Verbose                                      bool
+}
+
+func (cf *commonFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&cf.ID, "id", os.Getenv("CLIENT_ID"), "application client ID")
+	fs.StringVar(&cf.Secret, "secret", os.Getenv("CLIENT_SECRET"), "application client secret")
+	fs.StringVar(&cf.Code, "code", os.Getenv("AUTH_CODE"), "authorization code")
+	fs.StringVar(&cf.TokenCache, "cache", "token-cache.json", "token cache filename")
+	fs.StringVar(&cf.DebugDir, "debug", "", "set to a valid path to save the response XMLs there")
+	fs.StringVar(&cf.User, "user", "", "user ID (default: the authorized user)")
+	fs.BoolVar(&cf.Verbose, "v", false, "verbose logging")
+}
+
+// client returns the authorized Client.
+//
+// See https://developers.google.com/accounts/docs/OAuth2InstalledApp .
+func (cf *commonFlags) client() (*picago.Client, error) {
+	picago.DebugDir = cf.DebugDir
+	var Log func(...interface{}) error
+	if cf.Verbose {
+		Log = logKeyvals
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	client, err := picago.NewClient(ctx, cf.ID, cf.Secret, cf.Code, cf.TokenCache, Log)
+	if err != nil {
+		return nil, err
+	}
+	return &picago.Client{Client: client}, nil
+}
+
+// logKeyvals logs the key-value pairs, as the Log funcs of picago expect.
+func logKeyvals(keyvals ...interface{}) error {
+	log.Println(keyvals...)
+	return nil
+}
+
+// authorize returns the authorized Client, or logs the error and returns
+// exitAuth.
+func (cf *commonFlags) authorize() (*picago.Client, int) {
+	client, err := cf.client()
+	if err != nil {
+		log.Printf("error with authorization: %v", err)
+		return nil, exitAuth
+	}
+	return client, exitOK
+}
+
+// filterFlags are the flags selecting albums and photos.
+type filterFlags struct {
+	album, noAlbum, albumID, noAlbumID, typ, noType, rights string
+	since, until, updatedSince, updatedUntil, media, where  string
+}
+
+func (ff *filterFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&ff.album, "album", "", "only the albums whose title matches this regexp")
+	fs.StringVar(&ff.noAlbum, "exclude-album", "", "skip the albums whose title matches this regexp")
+	fs.StringVar(&ff.albumID, "album-id", "", "only these albums (comma-separated IDs)")
+	fs.StringVar(&ff.noAlbumID, "exclude-album-id", "", "skip these albums (comma-separated IDs)")
+	fs.StringVar(&ff.typ, "type", "", `only these album types (comma-separated, e.g. "InstantUpload,"; empty item for ordinary albums)`)
+	fs.StringVar(&ff.noType, "exclude-type", "", "skip these album types (comma-separated, e.g. Blogger,ProfilePhotos)")
+	fs.StringVar(&ff.rights, "rights", "", "only the albums with these rights (comma-separated, e.g. public,protected)")
+	fs.StringVar(&ff.since, "since", "", "only the albums published at or after this date (2006-01-02 or RFC3339)")
+	fs.StringVar(&ff.until, "until", "", "only the albums published before this date")
+	fs.StringVar(&ff.updatedSince, "updated-since", "", "only the albums updated at or after this date")
+	fs.StringVar(&ff.updatedUntil, "updated-until", "", "only the albums updated before this date")
+	fs.StringVar(&ff.media, "media", "all", "only image, video or all")
+	fs.StringVar(&ff.where, "where", "", `select the photos (and albums) by an expression, e.g. video and exif.make contains "canon" and year == 2013 and keywords contains 'kids'
+(fields: `+strings.Join(picago.ExprFields(), ", ")+`)`)
+}
+
+// filter returns the Filter of the flags.
+func (ff *filterFlags) filter() (picago.Filter, error) {
+	filter := picago.Filter{
+		IncludeIDs:   splitList(ff.albumID),
+		ExcludeIDs:   splitList(ff.noAlbumID),
+		IncludeTypes: splitList(ff.typ),
+		ExcludeTypes: splitList(ff.noType),
+		Rights:       splitList(ff.rights),
+	}
+	var err error
+	if filter.Media, err = picago.ParseMediaKind(ff.media); err != nil {
+		return filter, fmt.Errorf("bad -media: %v", err)
+	}
+	if ff.where != "" {
+		if filter.Where, err = picago.ParseExpr(ff.where); err != nil {
+			if ee, ok := err.(*picago.ExprError); ok {
+				return filter, fmt.Errorf("bad -where: %v\n\t%s\n\t%s^", err, ff.where, strings.Repeat(" ", ee.Pos))
+			}
+			return filter, fmt.Errorf("bad -where: %v", err)
+		}
+	}
+	for _, re := range []struct {
+		flag string
+		dst  **regexp.Regexp
+	}{{ff.album, &filter.IncludeTitle}, {ff.noAlbum, &filter.ExcludeTitle}} {
+		if re.flag == "" {
+			continue
+		}
+		if *re.dst, err = regexp.Compile(re.flag); err != nil {
+			return filter, fmt.Errorf("bad album regexp %q: %v", re.flag, err)
+		}
+	}
+	for _, d := range []struct {
+		flag string
+		dst  *time.Time
+	}{
+		{ff.since, &filter.PublishedAfter}, {ff.until, &filter.PublishedBefore},
+		{ff.updatedSince, &filter.UpdatedAfter}, {ff.updatedUntil, &filter.UpdatedBefore},
+	} {
+		if *d.dst, err = parseDate(d.flag); err != nil {
+			return filter, fmt.Errorf("bad date %q: %v", d.flag, err)
+		}
+	}
+	return filter, nil
+}
+
+// splitList splits the comma-separated list s, nil if s is empty.
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	list := strings.Split(s, ",")
+	for i, x := range list {
+		list[i] = strings.TrimSpace(x)
+	}
+	return list
+}
+
+// parseDate parses s as a date (2006-01-02, in local time) or as RFC3339.
+// The empty string is the zero time.
+func parseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
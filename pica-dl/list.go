@@ -0,0 +1,207 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/tgulacsi/picago"
+)
+
+func runAuth(cmd *command, args []string) int {
+	fs, cf := cmd.flagSet()
+	if err := fs.Parse(args); err != nil {
+		return parseExit(err)
+	}
+	if fs.NArg() != 0 {
+		return badUsage(fs, "no arguments expected")
+	}
+	if cf.TokenCache == "" {
+		return badUsage(fs, "auth needs -cache")
+	}
+	if _, code := cf.authorize(); code != exitOK {
+		return code
+	}
+	fmt.Fprintf(os.Stderr, "The token is stored in %s.\n", cf.TokenCache)
+	return exitOK
+}
+
+func runWhoami(cmd *command, args []string) int {
+	fs, cf := cmd.flagSet()
+	asJSON := fs.Bool("json", false, "print JSON")
+	if err := fs.Parse(args); err != nil {
+		return parseExit(err)
+	}
+	if fs.NArg() != 0 {
+		return badUsage(fs, "no arguments expected")
+	}
+	client, code := cf.authorize()
+	if code != exitOK {
+		return code
+	}
+	user, err := client.GetUser(cf.User)
+	if err != nil {
+		return fail("error getting the user: %v", err)
+	}
+	if *asJSON {
+		return printJSON(user)
+	}
+	fmt.Printf("%s\t%s\t%s\n", user.ID, user.Name, user.URI)
+	return exitOK
+}
+
+func runAlbums(cmd *command, args []string) int {
+	fs, cf := cmd.flagSet()
+	var ff filterFlags
+	ff.register(fs)
+	asJSON := fs.Bool("json", false, "print JSON, one album per line")
+	if err := fs.Parse(args); err != nil {
+		return parseExit(err)
+	}
+	if fs.NArg() != 0 {
+		return badUsage(fs, "no arguments expected")
+	}
+	filter, err := ff.filter()
+	if err != nil {
+		return badUsage(fs, "%v", err)
+	}
+	client, code := cf.authorize()
+	if code != exitOK {
+		return code
+	}
+	albums, err := client.GetAlbums(cf.User)
+	if err != nil {
+		return fail("error listing albums: %v", err)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	enc := json.NewEncoder(os.Stdout)
+	for _, a := range filter.Albums(albums) {
+		if *asJSON {
+			if err = enc.Encode(a); err != nil {
+				return fail("%v", err)
+			}
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", a.ID, a.NumPhotos, a.Published.Format("2006-01-02"), a.AlbumType, a.Title)
+	}
+	if err = tw.Flush(); err != nil {
+		return fail("%v", err)
+	}
+	return exitOK
+}
+
+func runPhotos(cmd *command, args []string) int {
+	fs, cf := cmd.flagSet()
+	var ff filterFlags
+	ff.register(fs)
+	asJSON := fs.Bool("json", false, "print JSON, one photo per line")
+	if err := fs.Parse(args); err != nil {
+		return parseExit(err)
+	}
+	if fs.NArg() != 1 {
+		return badUsage(fs, "one album expected")
+	}
+	filter, err := ff.filter()
+	if err != nil {
+		return badUsage(fs, "%v", err)
+	}
+	client, code := cf.authorize()
+	if code != exitOK {
+		return code
+	}
+	album, err := findAlbum(client, cf.User, fs.Arg(0))
+	if err != nil {
+		return fail("%v", err)
+	}
+	photos, err := client.GetPhotos(cf.User, album.ID)
+	if err != nil {
+		return fail("error listing photos of %s: %v", album.ID, err)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	enc := json.NewEncoder(os.Stdout)
+	for _, p := range filter.Photos(album, photos) {
+		if *asJSON {
+			if err = enc.Encode(p); err != nil {
+				return fail("%v", err)
+			}
+			continue
+		}
+		t, _ := p.Time(picago.DefaultTimeOrder)
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", p.ID, p.Size, t.Format("2006-01-02 15:04"), p.Type, p.Filename)
+	}
+	if err = tw.Flush(); err != nil {
+		return fail("%v", err)
+	}
+	return exitOK
+}
+
+func runInfo(cmd *command, args []string) int {
+	fs, cf := cmd.flagSet()
+	if err := fs.Parse(args); err != nil {
+		return parseExit(err)
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		return badUsage(fs, "an album and optionally a photo (ID or file name) expected")
+	}
+	client, code := cf.authorize()
+	if code != exitOK {
+		return code
+	}
+	album, err := findAlbum(client, cf.User, fs.Arg(0))
+	if err != nil {
+		return fail("%v", err)
+	}
+	if fs.NArg() == 1 {
+		return printJSON(album)
+	}
+	photos, err := client.GetPhotos(cf.User, album.ID)
+	if err != nil {
+		return fail("error listing photos of %s: %v", album.ID, err)
+	}
+	for _, p := range photos {
+		if p.ID == fs.Arg(1) || p.Filename == fs.Arg(1) {
+			return printJSON(p)
+		}
+	}
+	return fail("no photo %q in album %s", fs.Arg(1), album.ID)
+}
+
+// findAlbum returns the album of the user with the given ID or title.
+func findAlbum(client *picago.Client, userID, idOrTitle string) (picago.Album, error) {
+	albums, err := client.GetAlbums(userID)
+	if err != nil {
+		return picago.Album{}, fmt.Errorf("error listing albums: %v", err)
+	}
+	for _, a := range albums {
+		if a.ID == idOrTitle {
+			return a, nil
+		}
+	}
+	var found []picago.Album
+	for _, a := range albums {
+		if a.Title == idOrTitle {
+			found = append(found, a)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return picago.Album{}, fmt.Errorf("no album %q", idOrTitle)
+	case 1:
+		return found[0], nil
+	}
+	return picago.Album{}, fmt.Errorf("%d albums are titled %q, use the ID", len(found), idOrTitle)
+}
+
+func printJSON(v interface{}) int {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fail("%v", err)
+	}
+	fmt.Printf("%s\n", b)
+	return exitOK
+}
@@ -2,117 +2,126 @@
 // Use of this source code is governed by an Apache 2.0
 // license that can be found in the LICENSE file.
 
-// pica-dl implements a simple Picasa Web downloader.
+// pica-dl is a command line client of Picasa Web: it lists, downloads
+// (or mirrors) and uploads albums and photos.
+//
+// Usage:
+//
+//	pica-dl COMMAND [flags] [arguments]
+//
+// See "pica-dl help" for the commands, and "pica-dl help COMMAND" for
+// their flags.
 package main
 
 import (
-	"context"
-	"encoding/json"
 	"flag"
-	"io"
-	"io/ioutil"
+	"fmt"
 	"log"
-	"net/http"
 	"os"
-	"path/filepath"
-	"time"
+	"strings"
+)
 
-	"github.com/tgulacsi/picago"
+// The exit codes.
+const (
+	exitOK      = 0
+	exitFailure = 1 // the command, or some of its items, failed
+	exitUsage   = 2 // bad command line
+	exitAuth    = 3 // authorization failed
 )
 
-// See https://developers.google.com/accounts/docs/OAuth2InstalledApp .
-func main() {
-	flagID := flag.String("id", os.Getenv("CLIENT_ID"), "application client ID")
-	flagSecret := flag.String("secret", os.Getenv("CLIENT_SECRET"), "application client secret")
-	flagCode := flag.String("code", os.Getenv("AUTH_CODE"), "authorization code")
-	flagTokenCache := flag.String("cache", "token-cache.json", "token cache filename")
-	flagDir := flag.String("dir", "", "directory to download images to")
-	flagDebugDir := flag.String("debug", "", "set to a valid path to save the response XMLs there")
-	flagVerbose := flag.Bool("v", false, "verbose logging")
+// A command is a subcommand of pica-dl. Run gets the arguments after
+// the command's name, and returns the exit code.
+type command struct {
+	Name, Args, Short string
+	Run               func(cmd *command, args []string) int
+}
 
-	flag.Parse()
-	picago.DebugDir = *flagDebugDir
-	userid := flag.Arg(0)
+var commands = []*command{
+	{Name: "auth", Short: "authorize, and store the token in the cache", Run: runAuth},
+	{Name: "whoami", Short: "print the user", Run: runWhoami},
+	{Name: "albums", Short: "list the albums", Run: runAlbums},
+	{Name: "photos", Args: "ALBUM", Short: "list the photos of the album (ID or title)", Run: runPhotos},
+	{Name: "info", Args: "ALBUM [PHOTO]", Short: "print all the data of an album or photo as JSON", Run: runInfo},
+	{Name: "download", Short: "download (or with -sync, mirror) the albums into -dir", Run: runDownload},
+	{Name: "upload", Args: "DIR...", Short: "upload each directory as an album, created if needed", Run: runUpload},
+	{Name: "restore", Args: "DIR", Short: "recreate the albums of a downloaded mirror, print the ID mapping", Run: runRestore},
+}
 
-	var Log func(...interface{}) error
-	if *flagVerbose {
-		Log = func(keyvals ...interface{}) error {
-			log.Println(keyvals...)
-			return nil
+func main() {
+	log.SetPrefix("pica-dl: ")
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(exitUsage)
+	}
+	name, args := os.Args[1], os.Args[2:]
+	switch name {
+	case "help", "-h", "-help", "--help":
+		if len(args) == 0 {
+			usage()
+			os.Exit(exitOK)
 		}
+		name, args = args[0], []string{"-h"}
 	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
-	client, err := picago.NewClient(ctx, *flagID, *flagSecret, *flagCode, *flagTokenCache, Log)
-	cancel()
-	if err != nil {
-		log.Fatalf("error with authorization: %v", err)
+	if strings.HasPrefix(name, "-") {
+		// The flat command line of the old versions.
+		log.Printf("no command given, assuming download")
+		name, args = "download", os.Args[1:]
 	}
-	user, err := picago.GetUser(client, "")
-	log.Printf("user=%#v err=%v", user, err)
+	for _, cmd := range commands {
+		if cmd.Name == name {
+			os.Exit(cmd.Run(cmd, args))
+		}
+	}
+	fmt.Fprintf(os.Stderr, "pica-dl: unknown command %q\n\n", name)
+	usage()
+	os.Exit(exitUsage)
+}
 
-	albums, err := picago.GetAlbums(client, userid)
-	if err != nil {
-		log.Fatalf("error listing albums: %v", err)
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: pica-dl COMMAND [flags] [arguments]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-9s %-14s %s\n", cmd.Name, cmd.Args, cmd.Short)
 	}
-	log.Printf("user %s has %d albums.", userid, len(albums))
+	fmt.Fprintf(os.Stderr, `
+Run "pica-dl help COMMAND" for the flags of the command.
 
-	download := *flagDir != ""
-	var dir, fn string
-	for _, album := range albums {
-		albumJ, err := json.Marshal(album)
-		if err != nil {
-			log.Fatalf("error marshaling %#v: %v", album, err)
-		}
-		if download {
-			dir = filepath.Join(*flagDir, album.Name)
-			if err = os.MkdirAll(dir, 0750); err != nil {
-				log.Fatalf("cannot create directory %s: %v", dir, err)
-			}
-			fn = filepath.Join(dir, "album-"+album.Name+".json")
-			if err = ioutil.WriteFile(fn, albumJ, 0750); err != nil {
-				log.Fatalf("error writing %s: %v", fn, err)
-			}
-		}
-		log.Printf("downloading album %s.", albumJ)
-		photos, err := picago.GetPhotos(client, userid, album.ID)
-		if err != nil {
-			log.Printf("error listing photos of %s: %v", album.ID, err)
-			continue
-		}
-		log.Printf("album %s contains %d photos.", album.ID, len(photos))
-		for _, photo := range photos {
-			photoJ, err := json.Marshal(photo)
-			if err != nil {
-				log.Fatalf("error marshaling %#v: %v", photo, err)
-			}
-			log.Printf("Photo: %s", photoJ)
+Exit codes:
+  %d  success
+  %d  the command, or some of its items, failed
+  %d  bad command line
+  %d  authorization failed
+`, exitOK, exitFailure, exitUsage, exitAuth)
+}
 
-			if !download {
-				continue
-			}
-			fn = filepath.Join(dir, photo.Filename)
-			if err = ioutil.WriteFile(fn+".json", photoJ, 0750); err != nil {
-				log.Fatalf("error writing %s.json: %v", fn, err)
-			}
-			if err = downloadTo(fn, client, photo.URL); err != nil {
-				log.Fatalf("downloading %s: %v", photo.URL, err)
-			}
-		}
+// flagSet returns the FlagSet of the command, with the common flags.
+func (cmd *command) flagSet() (*flag.FlagSet, *commonFlags) {
+	fs := flag.NewFlagSet(cmd.Name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: pica-dl %s [flags] %s\n\n%s.\n\nFlags:\n", cmd.Name, cmd.Args, cmd.Short)
+		fs.PrintDefaults()
 	}
+	var cf commonFlags
+	cf.register(fs)
+	return fs, &cf
 }
 
-func downloadTo(fn string, client *http.Client, url string) error {
-	body, err := picago.DownloadPhoto(client, url)
-	if err != nil {
-		return err
+// parseExit returns the exit code for the error of FlagSet.Parse.
+func parseExit(err error) int {
+	if err == flag.ErrHelp {
+		return exitOK
 	}
-	defer body.Close()
-	fh, err := os.Create(fn)
-	if err != nil {
-		return err
-	}
-	defer fh.Close()
-	_, err = io.Copy(fh, body)
-	return err
+	return exitUsage
+}
+
+// badUsage prints the message and the usage of the FlagSet.
+func badUsage(fs *flag.FlagSet, format string, args ...interface{}) int {
+	fmt.Fprintf(os.Stderr, "pica-dl "+fs.Name()+": "+format+"\n", args...)
+	fs.Usage()
+	return exitUsage
+}
+
+// fail logs the message and returns exitFailure.
+func fail(format string, args ...interface{}) int {
+	log.Printf(format, args...)
+	return exitFailure
 }
@@ -0,0 +1,40 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/tgulacsi/picago"
+)
+
+// printPlan prints the summary of the plan (with verbose, its items, too),
+// or the whole plan as JSON.
+func printPlan(plan picago.Plan, asJSON, verbose bool) int {
+	if asJSON {
+		return printJSON(plan)
+	}
+	if verbose {
+		for _, it := range plan.Items {
+			kind := "photo"
+			if it.Album {
+				kind = "album"
+			}
+			fmt.Printf("%s\t%s\t%s", it.Op, kind, it.Path)
+			if it.OldPath != "" {
+				fmt.Printf(" <- %s", it.OldPath)
+			}
+			if it.Bytes >= 0 && !it.Album {
+				fmt.Printf("\t%d", it.Bytes)
+			}
+			if it.Err != "" {
+				fmt.Printf("\terror: %s", it.Err)
+			}
+			fmt.Println()
+		}
+	}
+	fmt.Print(plan)
+	return exitOK
+}
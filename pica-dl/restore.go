@@ -0,0 +1,99 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/tgulacsi/picago"
+)
+
+func runRestore(cmd *command, args []string) int {
+	fs, cf := cmd.flagSet()
+	flagConcurrency := fs.Int("concurrency", 4, "number of parallel uploads")
+	flagRetries := fs.Int("retries", 3, "number of retries of a failed upload")
+	flagPlan := fs.Bool("plan", false, "only print what would be uploaded and skipped, with the byte totals, without uploading anything")
+	flagJSON := fs.Bool("json", false, "with -plan, print the whole plan as JSON")
+	flagMap := fs.String("map", "", "write the old to new ID mapping into this file instead of the standard output; if it exists, the albums of the previous restore written there are reused")
+	if err := fs.Parse(args); err != nil {
+		return parseExit(err)
+	}
+	if fs.NArg() != 1 {
+		return badUsage(fs, "one mirror directory expected")
+	}
+	client, code := cf.authorize()
+	if code != exitOK {
+		return code
+	}
+	var prev picago.RestoreMap
+	if *flagMap != "" {
+		b, err := ioutil.ReadFile(*flagMap)
+		if err == nil {
+			err = json.Unmarshal(b, &prev)
+		} else if os.IsNotExist(err) {
+			err = nil
+		}
+		if err != nil {
+			return fail("error reading %s: %v", *flagMap, err)
+		}
+	}
+	u := picago.Uploader{
+		Client:      client,
+		UserID:      cf.User,
+		Concurrency: *flagConcurrency,
+		Retries:     *flagRetries,
+	}
+	u.BlobDir = blobDirOf(fs.Arg(0))
+	if *flagPlan {
+		plan, err := u.PlanRestore(context.Background(), fs.Arg(0), prev)
+		if err != nil {
+			return fail("error planning the restore of %s: %v", fs.Arg(0), err)
+		}
+		return printPlan(plan, *flagJSON, cf.Verbose)
+	}
+	idMap, results, err := u.Restore(context.Background(), fs.Arg(0), prev)
+	var failed int
+	for _, res := range results {
+		if res.Err != nil {
+			failed++
+			log.Printf("restoring %s: %v", res.Dir, res.Err)
+			continue
+		}
+		log.Printf("restored %s as album %s (%s).", res.Dir, res.Album.ID, res.Album.Title)
+		for _, up := range res.Uploads {
+			if up.Err != nil {
+				failed++
+				log.Printf("uploading %s: %v", up.Path, up.Err)
+			}
+		}
+	}
+	if err != nil {
+		failed++
+		log.Printf("restoring %s: %v", fs.Arg(0), err)
+	}
+	log.Printf("restored albums=%d photos=%d failed=%d", len(idMap.Albums), len(idMap.Photos), failed)
+
+	if *flagMap == "" {
+		if code = printJSON(idMap); code != exitOK {
+			return code
+		}
+	} else {
+		b, err := json.MarshalIndent(idMap, "", "  ")
+		if err == nil {
+			err = ioutil.WriteFile(*flagMap, append(b, '\n'), 0640)
+		}
+		if err != nil {
+			return fail("error writing %s: %v", *flagMap, err)
+		}
+	}
+	if failed > 0 {
+		return exitFailure
+	}
+	return exitOK
+}
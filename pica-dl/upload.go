@@ -0,0 +1,82 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"log"
+	"path/filepath"
+
+	"github.com/tgulacsi/picago"
+)
+
+func runUpload(cmd *command, args []string) int {
+	fs, cf := cmd.flagSet()
+	flagConcurrency := fs.Int("concurrency", 4, "number of parallel uploads")
+	flagRetries := fs.Int("retries", 3, "number of retries of a failed upload")
+	flagPlan := fs.Bool("plan", false, "only print what would be uploaded and skipped, with the byte totals, without uploading anything")
+	flagJSON := fs.Bool("json", false, "with -plan, print the whole plan as JSON")
+	if err := fs.Parse(args); err != nil {
+		return parseExit(err)
+	}
+	if fs.NArg() == 0 {
+		return badUsage(fs, "at least one directory expected")
+	}
+	client, code := cf.authorize()
+	if code != exitOK {
+		return code
+	}
+	u := picago.Uploader{
+		Client:      client,
+		UserID:      cf.User,
+		Concurrency: *flagConcurrency,
+		Retries:     *flagRetries,
+	}
+	if *flagPlan {
+		var plan picago.Plan
+		for _, dir := range fs.Args() {
+			u.BlobDir = blobDirOf(filepath.Dir(dir))
+			dirPlan, err := u.PlanDir(context.Background(), dir)
+			if err != nil {
+				return fail("error planning the upload of %s: %v", dir, err)
+			}
+			for _, it := range dirPlan.Items {
+				plan.Add(it)
+			}
+		}
+		return printPlan(plan, *flagJSON, cf.Verbose)
+	}
+	var uploaded, skipped, failed int
+	for _, dir := range fs.Args() {
+		u.BlobDir = blobDirOf(filepath.Dir(dir))
+		album, results, err := u.UploadDir(context.Background(), dir)
+		if err != nil {
+			log.Printf("uploading %s: %v", dir, err)
+			failed++
+			continue
+		}
+		log.Printf("uploading %s into album %s (%s).", dir, album.ID, album.Title)
+		for _, res := range results {
+			switch {
+			case res.Err != nil:
+				failed++
+				log.Printf("uploading %s: %v", res.Path, res.Err)
+			case res.Skipped:
+				skipped++
+				if cf.Verbose {
+					log.Printf("%s is already there as %s.", res.Path, res.Uploaded.ID)
+				}
+			default:
+				uploaded++
+				log.Printf("uploaded %s as %s.", res.Path, res.Uploaded.ID)
+			}
+		}
+	}
+	log.Printf("uploaded=%d skipped=%d failed=%d", uploaded, skipped, failed)
+	if failed > 0 {
+		return exitFailure
+	}
+	return exitOK
+}
@@ -0,0 +1,498 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package picago
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// A PlanItem is a change which a Sync, Download or Upload would do.
+type PlanItem struct {
+	Op SyncOp
+
+	// Album is true for the items of albums, false for photos.
+	Album bool `json:",omitempty"`
+
+	// AlbumID and PhotoID are empty for the albums and photos to upload.
+	AlbumID string `json:",omitempty"`
+	PhotoID string `json:",omitempty"`
+
+	// Path is the local path of the album directory or photo file,
+	// OldPath is the previous one for SyncRename.
+	Path    string `json:",omitempty"`
+	OldPath string `json:",omitempty"`
+
+	// Bytes is the size of the transfer: for a SyncUpdate, the rest of a
+	// partial download. For a SyncSkip or SyncDelete, it is the size of
+	// the photo already there. -1 if unknown (e.g. videos).
+	Bytes int64
+
+	// Err tells why the change would fail, e.g. a missing variant.
+	Err string `json:",omitempty"`
+}
+
+// A PlanTotal sums the items of a Plan with the same SyncOp.
+type PlanTotal struct {
+	Count int
+	Bytes int64
+
+	// Unknown is the number of items of unknown size, not in Bytes.
+	Unknown int `json:",omitempty"`
+}
+
+// A Plan is what a Sync, Download or Upload would do, computed without
+// writing files or changing anything remotely.
+type Plan struct {
+	// Albums and Photos are the totals of the items, by SyncOp.
+	Albums, Photos map[SyncOp]PlanTotal
+
+	// Errors is the number of items which would fail.
+	Errors int `json:",omitempty"`
+
+	Items []PlanItem
+}
+
+// planOps are the SyncOps in the order of printing.
+var planOps = []SyncOp{SyncAdd, SyncUpdate, SyncRename, SyncDelete, SyncSkip}
+
+// Add appends the item to the plan, and counts it in the totals.
+func (pl *Plan) Add(it PlanItem) {
+	totals := &pl.Photos
+	if it.Album {
+		totals = &pl.Albums
+	}
+	if *totals == nil {
+		*totals = make(map[SyncOp]PlanTotal)
+	}
+	t := (*totals)[it.Op]
+	t.Count++
+	if it.Bytes < 0 {
+		t.Unknown++
+	} else {
+		t.Bytes += it.Bytes
+	}
+	(*totals)[it.Op] = t
+	if it.Err != "" {
+		pl.Errors++
+	}
+	pl.Items = append(pl.Items, it)
+}
+
+// String returns the summary of the plan, e.g.
+//
+//	albums: add 1, skip 3
+//	photos: add 12 (48.5 MiB, 1 of unknown size), skip 310 (1.2 GiB)
+func (pl Plan) String() string {
+	var buf bytes.Buffer
+	for _, x := range []struct {
+		name   string
+		totals map[SyncOp]PlanTotal
+	}{{"albums", pl.Albums}, {"photos", pl.Photos}} {
+		fmt.Fprintf(&buf, "%s:", x.name)
+		sep := " "
+		for _, op := range planOps {
+			t, ok := x.totals[op]
+			if !ok {
+				continue
+			}
+			fmt.Fprintf(&buf, "%s%s %d", sep, op, t.Count)
+			sep = ", "
+			if x.name == "albums" {
+				continue
+			}
+			if t.Unknown == 0 {
+				fmt.Fprintf(&buf, " (%s)", formatBytes(t.Bytes))
+			} else {
+				fmt.Fprintf(&buf, " (%s, %d of unknown size)", formatBytes(t.Bytes), t.Unknown)
+			}
+		}
+		if sep == " " {
+			buf.WriteString(" none")
+		}
+		buf.WriteByte('\n')
+	}
+	if pl.Errors != 0 {
+		fmt.Fprintf(&buf, "errors: %d\n", pl.Errors)
+	}
+	return buf.String()
+}
+
+// formatBytes returns n in human readable form, such as 1.5 KiB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// Plan returns what Download would do with the jobs, without downloading
+// anything: the photos to add, to update (resume a partial download, or
+// download again over an existing file) and to skip (downloaded already,
+// or known by the Dedupe store).
+//
+// If the Destination is not a PathRenderer, its Path may create directories.
+func (d *Downloader) Plan(jobs []DownloadJob) Plan {
+	var pl Plan
+	for _, job := range jobs {
+		p, err := d.variant(job.Photo)
+		var path string
+		if err == nil {
+			if r, ok := d.Destination.(PathRenderer); ok {
+				path, err = r.Render(job.Album, p)
+			} else {
+				path, err = d.Destination.Path(job.Album, p)
+			}
+		}
+		if err != nil {
+			pl.Add(PlanItem{Op: SyncAdd, AlbumID: job.Album.ID, PhotoID: p.ID, Bytes: -1, Err: err.Error()})
+			continue
+		}
+		pl.Add(d.planPhoto(job.Album, job.Photo, path, !job.Changed))
+	}
+	return pl
+}
+
+// PlanAlbums returns what downloading the photos of the albums selected by
+// the filter would do, without writing anything, with a *Layout or
+// *PathTemplate Destination. An album whose photos cannot be listed is
+// planned with the error, like Sync goes on with the other albums.
+func (d *Downloader) PlanAlbums(userID string, albums []Album, filter Filter) Plan {
+	var pl Plan
+	layout, _ := d.Destination.(*Layout)
+	tmpl, _ := d.Destination.(*PathTemplate)
+	listed := make(map[string][]Photo, len(albums))
+	errs := make(map[string]error)
+	for _, a := range albums {
+		photos, err := d.Client.GetPhotos(userID, a.ID)
+		if err != nil {
+			errs[a.ID] = err
+			continue
+		}
+		listed[a.ID] = photos
+		if tmpl != nil {
+			// All the albums are registered before rendering any path.
+			tmpl.AddAlbum(a, photos)
+		}
+	}
+	for _, a := range albums {
+		it := PlanItem{Op: SyncAdd, Album: true, AlbumID: a.ID}
+		if layout != nil {
+			it.Path = filepath.Join(layout.Dir, AlbumDirName(a))
+		}
+		if err := errs[a.ID]; err != nil {
+			it.Err = err.Error()
+			pl.Add(it)
+			continue
+		}
+		photos := listed[a.ID]
+		var oldDir string
+		if layout != nil {
+			if it.Path, oldDir = layout.PlanAlbum(a, photos); oldDir != "" {
+				it.Op, it.OldPath = SyncRename, oldDir
+				// The photos are still in the old directory.
+				d.Destination = DestinationFunc(func(a Album, p Photo) (string, error) {
+					path, err := layout.Path(a, p)
+					return filepath.Join(oldDir, filepath.Base(path)), err
+				})
+			}
+		}
+
+		var jobs []DownloadJob
+		for _, p := range filter.Photos(a, photos) {
+			jobs = append(jobs, DownloadJob{Album: a, Photo: p})
+		}
+		items := d.Plan(jobs).Items
+		if layout == nil && len(items) != 0 {
+			// The directory of the first photo, as the album's.
+			it.Path = filepath.Dir(items[0].Path)
+		}
+		if _, err := os.Stat(filepath.Join(it.Path, AlbumSidecarName)); err == nil && it.Op == SyncAdd {
+			it.Op = SyncUpdate
+		}
+		pl.Add(it)
+		for _, pi := range items {
+			if oldDir != "" {
+				pi.Path = filepath.Join(it.Path, filepath.Base(pi.Path))
+			}
+			pl.Add(pi)
+		}
+		if oldDir != "" {
+			d.Destination = layout
+		}
+	}
+	return pl
+}
+
+// planPhoto returns the PlanItem of downloading the photo to path.
+// Unless resume (the photo has changed), neither a partial download there
+// nor the copy in the Dedupe store is taken into account.
+func (d *Downloader) planPhoto(a Album, p Photo, path string, resume bool) PlanItem {
+	it := PlanItem{Op: SyncAdd, AlbumID: a.ID, PhotoID: p.ID, Path: path, Bytes: p.Size}
+	v, err := d.variant(p)
+	if err != nil {
+		it.Bytes, it.Err = -1, err.Error()
+		return it
+	}
+	if p.Size <= 0 || p.IsVideo() || v.URL != p.URL {
+		// The size is of the original image only.
+		it.Bytes = -1
+	}
+	if d.Dedupe != nil && resume {
+		if blob, fi, _ := d.Dedupe.lookup(v); blob != "" {
+			it.Op, it.Bytes = SyncSkip, fi.Size()
+			return it
+		}
+	}
+	if _, err = os.Stat(path); err == nil || d.Dedupe != nil && d.Dedupe.Exists(path) {
+		it.Op = SyncUpdate
+	}
+	staged := d.EmbedMetadata && v.Type == "image/jpeg" || d.Dedupe != nil
+	part := path
+	if staged {
+		part += ".part"
+	}
+	fi, err := os.Stat(part)
+	if err != nil || !resume || it.Bytes < 0 {
+		return it
+	}
+	if !staged && fi.Size() == it.Bytes {
+		it.Op = SyncSkip
+	} else if _, err = os.Stat(part + validatorExt); err == nil && fi.Size() < it.Bytes {
+		// Only a validated partial download is resumed.
+		it.Op, it.Bytes = SyncUpdate, it.Bytes-fi.Size()
+	}
+	return it
+}
+
+// Plan returns what Sync would do, without writing anything: the albums
+// and photos to add, update (resume, or download again if changed), rename,
+// delete (or with Prune, remove) and skip. Photos whose complete copy is
+// there already, or in the Dedupe store, are skipped.
+//
+// The albums and photos are listed as by Sync, so the Filter applies,
+// and the remotely deleted ones are planned to be deleted.
+func (m *Mirror) Plan(ctx context.Context) (Plan, error) {
+	var pl Plan
+	man, err := LoadManifest(m.manifestPath())
+	if err != nil {
+		return pl, err
+	}
+	albums, err := m.Client.GetAlbums(m.UserID)
+	if err != nil {
+		return pl, err
+	}
+	seen := make(map[string]bool, len(albums))
+	for _, a := range albums {
+		if err = ctx.Err(); err != nil {
+			return pl, err
+		}
+		seen[a.ID] = true
+		if m.Filter != nil && !m.Filter.Album(a) {
+			continue
+		}
+		if err = m.planAlbum(&pl, man.Albums[a.ID], a); err != nil {
+			return pl, err
+		}
+	}
+	var gone []string
+	for id := range man.Albums {
+		if !seen[id] {
+			gone = append(gone, id)
+		}
+	}
+	sort.Strings(gone)
+	for _, id := range gone {
+		ma := man.Albums[id]
+		for _, pid := range ma.photoIDs() {
+			m.planDelete(&pl, ma, pid)
+		}
+		pl.Add(PlanItem{Op: SyncDelete, Album: true, AlbumID: id, Path: m.abs(ma.Dir)})
+	}
+	return pl, nil
+}
+
+// planAlbum adds the changes of the album (mirrored as ma, nil if new) to pl.
+func (m *Mirror) planAlbum(pl *Plan, ma *MirroredAlbum, a Album) error {
+	dir := AlbumDirName(a)
+	isNew := ma == nil
+	switch {
+	case isNew:
+		ma = &MirroredAlbum{ID: a.ID, Dir: dir}
+	case ma.Dir != dir:
+		pl.Add(PlanItem{Op: SyncRename, Album: true, AlbumID: a.ID, Path: m.abs(dir), OldPath: m.abs(ma.Dir)})
+	}
+	if m.unchanged(ma, a) {
+		pl.Add(PlanItem{Op: SyncSkip, Album: true, AlbumID: a.ID, Path: m.abs(dir)})
+		for _, pid := range ma.photoIDs() {
+			mp := ma.Photos[pid]
+			pl.Add(PlanItem{Op: SyncSkip, AlbumID: a.ID, PhotoID: pid, Path: m.abs(dir + "/" + path.Base(mp.Path)), Bytes: mp.Size})
+		}
+		return nil
+	}
+	it := PlanItem{Op: SyncUpdate, Album: true, AlbumID: a.ID, Path: m.abs(dir)}
+	if isNew {
+		it.Op = SyncAdd
+	}
+	photos, err := m.Client.GetPhotos(m.UserID, a.ID)
+	if err != nil {
+		// Sync goes on with the other albums, and retries this the next time.
+		it.Err = err.Error()
+	}
+	pl.Add(it)
+	if err != nil {
+		return nil
+	}
+	names := PhotoFileNames(photos)
+	dl := m.downloader(nil)
+	seen := make(map[string]bool, len(photos))
+	for _, p := range photos {
+		seen[p.ID] = true
+		if m.Filter != nil && !m.Filter.Photo(a, p) {
+			continue
+		}
+		// The photo is at cur now, Sync would move it to target.
+		target := dir + "/" + names[p.ID]
+		cur := target
+		mp := ma.Photos[p.ID]
+		if mp != nil {
+			cur = mp.Path
+			if moved := dir + "/" + path.Base(mp.Path); moved != target {
+				pl.Add(PlanItem{Op: SyncRename, AlbumID: a.ID, PhotoID: p.ID, Path: m.abs(target), OldPath: m.abs(moved)})
+			}
+		}
+		changed := mp != nil && (mp.ETag != p.ETag || !mp.Updated.Equal(p.Updated))
+		if mp != nil && mp.Complete && !changed && m.exists(cur) {
+			pl.Add(PlanItem{Op: SyncSkip, AlbumID: a.ID, PhotoID: p.ID, Path: m.abs(target), Bytes: mp.Size})
+			continue
+		}
+		it := dl.planPhoto(a, p, m.abs(cur), !changed)
+		if it.Op != SyncSkip {
+			// As counted by Sync, by the manifest.
+			it.Op = SyncAdd
+			if mp != nil {
+				it.Op = SyncUpdate
+			}
+		}
+		it.Path = m.abs(target)
+		pl.Add(it)
+	}
+	for _, pid := range ma.photoIDs() {
+		if !seen[pid] {
+			m.planDelete(pl, ma, pid)
+		}
+	}
+	return nil
+}
+
+func (m *Mirror) planDelete(pl *Plan, ma *MirroredAlbum, id string) {
+	mp := ma.Photos[id]
+	pl.Add(PlanItem{Op: SyncDelete, AlbumID: ma.ID, PhotoID: id, Path: m.abs(mp.Path), Bytes: mp.Size})
+}
+
+// photoIDs returns the IDs of the mirrored photos, sorted.
+func (ma *MirroredAlbum) photoIDs() []string {
+	ids := make([]string, 0, len(ma.Photos))
+	for id := range ma.Photos {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// PlanDir returns what UploadDir would do, without uploading or creating
+// anything: the album to add (or skip, if there is one with its title),
+// and the files to add or skip (if there already).
+func (u *Uploader) PlanDir(ctx context.Context, dir string) (Plan, error) {
+	var pl Plan
+	err := u.planDir(ctx, &pl, dir, func(a Album) (Album, bool, error) {
+		return u.findAlbum(a.Title)
+	})
+	return pl, err
+}
+
+// planDir adds the upload of dir to pl. find returns the existing album
+// for the album of dir, if any.
+func (u *Uploader) planDir(ctx context.Context, pl *Plan, dir string, find func(Album) (Album, bool, error)) error {
+	a, jobs, err := u.dirAlbum(dir)
+	if err != nil {
+		return err
+	}
+	old, ok, err := find(a)
+	if err != nil {
+		return err
+	}
+	var present map[string][]Photo
+	if ok {
+		pl.Add(PlanItem{Op: SyncSkip, Album: true, AlbumID: old.ID, Path: dir})
+		if present, err = u.present(old.ID); err != nil {
+			return err
+		}
+	} else {
+		pl.Add(PlanItem{Op: SyncAdd, Album: true, Path: dir})
+	}
+	for _, job := range jobs {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		it := PlanItem{Op: SyncAdd, AlbumID: old.ID, Path: job.Path}
+		// Only the candidates' checksums are needed, so only their files are read.
+		if candidates := present[filenameOf(job)]; len(candidates) == 0 {
+			var fi os.FileInfo
+			if fi, err = os.Stat(job.Path); err == nil {
+				it.Bytes = fi.Size()
+			}
+		} else {
+			var sum string
+			if sum, it.Bytes, err = fileChecksum(job.Path); err == nil {
+				if p := findPresent(candidates, sum, it.Bytes); p != nil {
+					it.Op, it.PhotoID = SyncSkip, p.ID
+				}
+			}
+		}
+		if err != nil {
+			it.Bytes, it.Err = -1, err.Error()
+		}
+		pl.Add(it)
+	}
+	return nil
+}
+
+// PlanRestore returns what Restore would do with prev, without uploading
+// or creating anything (see PlanDir).
+func (u *Uploader) PlanRestore(ctx context.Context, dir string, prev RestoreMap) (Plan, error) {
+	var pl Plan
+	dirs, err := restoreDirs(dir)
+	if err != nil {
+		return pl, err
+	}
+	if len(dirs) == 0 {
+		return pl, errNoAlbumDirs(dir)
+	}
+	existing, err := u.restoredAlbums(prev)
+	if err != nil {
+		return pl, err
+	}
+	for _, albumDir := range dirs {
+		if err = u.planDir(ctx, &pl, albumDir, func(a Album) (Album, bool, error) {
+			old, ok := existing[a.ID]
+			return old, ok, nil
+		}); err != nil {
+			return pl, err
+		}
+	}
+	return pl, nil
+}
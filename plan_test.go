@@ -0,0 +1,214 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package picago
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPlan(t *testing.T) {
+	fake := &fakePicasa{photos: make(map[string][]Photo), content: make(map[string]string)}
+	fake.albums = []Album{{ID: "1", Name: "Summer", Title: "Summer", ETag: "a1"}}
+	a, b := fake.photo("1", "11", "a.jpg", "p1", "aaaa"), fake.photo("1", "12", "b.jpg", "p1", "bbbbbb")
+	a.Size, b.Size = 4, 6
+	fake.photos["1"] = []Photo{a, b}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	root, err := ioutil.TempDir("", "picago-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	dir := filepath.Join(root, "mirror")
+	m := Mirror{
+		Client: &Client{Client: &http.Client{Transport: rewriteTransport{host: srv.Listener.Addr().String()}}},
+		Dir:    dir,
+	}
+
+	pl, err := m.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := pl.Albums[SyncAdd]; got.Count != 1 {
+		t.Errorf("got albums %+v", pl.Albums)
+	}
+	if got := pl.Photos[SyncAdd]; got.Count != 2 || got.Bytes != 10 || got.Unknown != 0 {
+		t.Errorf("got photos %+v", pl.Photos)
+	}
+	if _, err = os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("planning created %s: %v", dir, err)
+	}
+
+	if _, err = m.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if pl, err = m.Plan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := pl.Photos[SyncSkip]; len(pl.Photos) != 1 || got.Count != 2 || got.Bytes != 10 {
+		t.Errorf("got photos %+v", pl.Photos)
+	}
+
+	// a is changed, b is deleted, c is partially downloaded.
+	c := fake.photo("1", "13", "c.jpg", "p1", "cccccccc")
+	c.Size = 8
+	a.ETag = "p2"
+	fake.photos["1"] = []Photo{a, c}
+	fake.albums[0].ETag = "a2"
+	partial := filepath.Join(dir, AlbumDirName(fake.albums[0]), "c.jpg")
+	if err = ioutil.WriteFile(partial, []byte("ccc"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(partial+validatorExt, []byte(`"c"`), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if pl, err = m.Plan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := map[SyncOp]PlanTotal{SyncUpdate: {Count: 1, Bytes: 4}, SyncAdd: {Count: 1, Bytes: 5}, SyncDelete: {Count: 1, Bytes: 6}}
+	if len(pl.Photos) != len(want) {
+		t.Errorf("got photos %+v, wanted %+v", pl.Photos, want)
+	}
+	for op, w := range want {
+		if got := pl.Photos[op]; got != w {
+			t.Errorf("%s: got %+v, wanted %+v", op, got, w)
+		}
+	}
+	if pl.Albums[SyncUpdate].Count != 1 {
+		t.Errorf("got albums %+v", pl.Albums)
+	}
+
+	// The store is only read for planning.
+	blobs := filepath.Join(root, "blobs")
+	if m.Dedupe, err = OpenDeduper(blobs, DedupeHardlink); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Plan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(blobs); !os.IsNotExist(err) {
+		t.Errorf("planning created %s: %v", blobs, err)
+	}
+}
+
+func TestPlanAlbumFailed(t *testing.T) {
+	fake := &fakePicasa{photos: make(map[string][]Photo), content: make(map[string]string)}
+	fake.albums = []Album{
+		{ID: "1", Name: "Summer", Title: "Summer", ETag: "a1"},
+		{ID: "2", Name: "Winter", Title: "Winter", ETag: "a2"},
+	}
+	fake.photos["1"] = []Photo{fake.photo("1", "11", "a.jpg", "p1", "aaa")}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/albumid/2") {
+			http.Error(w, "oops", http.StatusInternalServerError)
+			return
+		}
+		fake.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	m := Mirror{
+		Client: &Client{Client: &http.Client{Transport: rewriteTransport{host: srv.Listener.Addr().String()}}},
+		Dir:    filepath.Join(os.TempDir(), "picago-missing"),
+	}
+	pl, err := m.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pl.Errors != 1 || pl.Albums[SyncAdd].Count != 2 || pl.Photos[SyncAdd].Count != 1 {
+		t.Errorf("got albums %+v, photos %+v, %d errors", pl.Albums, pl.Photos, pl.Errors)
+	}
+	for _, it := range pl.Items {
+		if (it.Err != "") != (it.Album && it.AlbumID == "2") {
+			t.Errorf("got %+v", it)
+		}
+	}
+}
+
+func TestPlanAlbums(t *testing.T) {
+	fake := &fakePicasa{photos: make(map[string][]Photo), content: make(map[string]string)}
+	fake.albums = []Album{
+		{ID: "1", Name: "Summer", Title: "Summer", ETag: "a1"},
+		{ID: "2", Name: "Winter", Title: "Winter", ETag: "a2"},
+	}
+	fake.photos["2"] = []Photo{fake.photo("2", "21", "a.jpg", "p1", "aaa")}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/albumid/1") {
+			http.Error(w, "oops", http.StatusInternalServerError)
+			return
+		}
+		fake.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "picago-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d := Downloader{
+		Client:      &Client{Client: &http.Client{Transport: rewriteTransport{host: srv.Listener.Addr().String()}}},
+		Destination: NewLayout(dir),
+	}
+	// The failed album doesn't stop the planning of the others.
+	pl := d.PlanAlbums("", fake.albums, Filter{})
+	if pl.Errors != 1 || pl.Albums[SyncAdd].Count != 2 || pl.Photos[SyncAdd].Count != 1 {
+		t.Errorf("got albums %+v, photos %+v, %d errors", pl.Albums, pl.Photos, pl.Errors)
+	}
+	if it := pl.Items[0]; !it.Album || it.AlbumID != "1" || it.Err == "" {
+		t.Errorf("got %+v, wanted the error of album 1", it)
+	}
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil || len(fis) != 0 {
+		t.Errorf("planning wrote %d files (%v)", len(fis), err)
+	}
+}
+
+func TestPlanDir(t *testing.T) {
+	fake := &uploadServer{fakePicasa: &fakePicasa{photos: make(map[string][]Photo), content: make(map[string]string)}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "picago-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, content := range map[string]string{"a.jpg": "aaa", "b.jpg": "bb"} {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	u := Uploader{Client: &Client{Client: &http.Client{Transport: rewriteTransport{host: srv.Listener.Addr().String()}}}}
+	pl, err := u.PlanDir(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pl.Albums[SyncAdd].Count != 1 || pl.Photos[SyncAdd] != (PlanTotal{Count: 2, Bytes: 5}) {
+		t.Errorf("got %+v", pl)
+	}
+	if len(fake.albums) != 0 {
+		t.Errorf("planning created albums %+v", fake.albums)
+	}
+
+	if _, _, err = u.UploadDir(context.Background(), dir); err != nil {
+		t.Fatal(err)
+	}
+	if pl, err = u.PlanDir(context.Background(), dir); err != nil {
+		t.Fatal(err)
+	}
+	if pl.Albums[SyncSkip].Count != 1 || len(pl.Photos) != 1 || pl.Photos[SyncSkip] != (PlanTotal{Count: 2, Bytes: 5}) {
+		t.Errorf("got %+v", pl)
+	}
+}
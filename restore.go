@@ -0,0 +1,144 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package picago
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A RestoreMap maps the IDs of the mirrored albums and photos to the
+// IDs of the restored ones.
+type RestoreMap struct {
+	Albums map[string]string
+	Photos map[string]string
+}
+
+// A RestoreResult is the outcome of restoring an album directory.
+type RestoreResult struct {
+	// Dir is the album's directory.
+	Dir string
+
+	// Album is the restored album, the zero Album if Err is not nil.
+	Album   Album
+	Uploads []UploadResult
+	Err     error
+}
+
+// Restore recreates the albums of a mirror (as written by Mirror.Sync or
+// pica-dl download) from their sidecars: every subdirectory of dir (at
+// any depth) with an AlbumSidecarName file is uploaded as by UploadDir,
+// so the albums get their titles, descriptions, locations and rights, and
+// the photos their captions, keywords and positions back.
+//
+// Albums are not matched by their titles, as those need not be unique:
+// only the albums of prev (the RestoreMap of a previous Restore, possibly
+// empty) which still exist are reused, with their photos, so an
+// interrupted Restore can be repeated. The photos of a DedupeBlobs mirror
+// are uploaded from BlobDir (see BlobUploadJobs).
+//
+// It is an error if dir has no album directories.
+//
+// The returned RestoreMap contains the albums and photos restored (or
+// found) successfully, by their IDs in the sidecars, and the albums of prev.
+func (u *Uploader) Restore(ctx context.Context, dir string, prev RestoreMap) (RestoreMap, []RestoreResult, error) {
+	m := RestoreMap{Albums: make(map[string]string), Photos: make(map[string]string)}
+	for k, v := range prev.Albums {
+		m.Albums[k] = v
+	}
+	for k, v := range prev.Photos {
+		m.Photos[k] = v
+	}
+	dirs, err := restoreDirs(dir)
+	if err != nil {
+		return m, nil, err
+	}
+	if len(dirs) == 0 {
+		return m, nil, errNoAlbumDirs(dir)
+	}
+	existing, err := u.restoredAlbums(prev)
+	if err != nil {
+		return m, nil, err
+	}
+	var results []RestoreResult
+	for _, albumDir := range dirs {
+		if err = ctx.Err(); err != nil {
+			return m, results, err
+		}
+		res := RestoreResult{Dir: albumDir}
+		var oldID string
+		res.Album, res.Uploads, res.Err = u.uploadDir(ctx, albumDir, func(a Album) (Album, error) {
+			oldID = a.ID
+			if old, ok := existing[a.ID]; ok {
+				return old, nil
+			}
+			return u.Client.CreateAlbum(u.UserID, a)
+		})
+		if res.Err == nil && oldID != "" {
+			m.Albums[oldID] = res.Album.ID
+		}
+		for _, up := range res.Uploads {
+			if up.Err == nil && up.Photo.ID != "" {
+				m.Photos[up.Photo.ID] = up.Uploaded.ID
+			}
+		}
+		results = append(results, res)
+	}
+	return m, results, nil
+}
+
+// restoreDirs returns the album directories of the mirror in dir: its
+// subdirectories (at any depth, but not the hidden ones) with an
+// AlbumSidecarName file.
+func restoreDirs(dir string) ([]string, error) {
+	var dirs []string
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.IsDir() || path == dir {
+			return err
+		}
+		if strings.HasPrefix(fi.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if _, err = os.Stat(filepath.Join(path, AlbumSidecarName)); err == nil {
+			dirs = append(dirs, path)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	})
+	return dirs, err
+}
+
+type errNoAlbumDirs string
+
+func (e errNoAlbumDirs) Error() string {
+	return fmt.Sprintf("no album directories (with %s) in %s", AlbumSidecarName, string(e))
+}
+
+// restoredAlbums returns the existing albums of prev, by their IDs in
+// the sidecars.
+func (u *Uploader) restoredAlbums(prev RestoreMap) (map[string]Album, error) {
+	existing := make(map[string]Album, len(prev.Albums))
+	if len(prev.Albums) == 0 {
+		return existing, nil
+	}
+	albums, err := u.Client.GetAlbums(u.UserID)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]Album, len(albums))
+	for _, a := range albums {
+		byID[a.ID] = a
+	}
+	for old, id := range prev.Albums {
+		if a, ok := byID[id]; ok {
+			existing[old] = a
+		}
+	}
+	return existing, nil
+}
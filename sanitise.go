@@ -0,0 +1,144 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package picago
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// MaxNameLen is the maximal length of a sanitised name in bytes. It leaves
+// room for sidecar extensions within the usual 255 byte limit.
+const MaxNameLen = 240
+
+// SanitiseName makes name usable as a single file or directory name on
+// Linux, macOS and Windows alike:
+//
+//   - the name is NFC normalised,
+//   - slashes, backslashes, control characters and the characters
+//     forbidden on Windows (<>:"|?*) are replaced with '_',
+//   - leading spaces and trailing dots and spaces are removed,
+//   - Windows-reserved names (CON, NUL, COM1, ...) get a '_' prefix,
+//   - names longer than MaxNameLen are shortened, keeping the extension.
+//
+// The empty name, "." and ".." become "_".
+// Case-insensitive collisions are resolved by PhotoFileNames.
+func SanitiseName(name string) string {
+	name = norm.NFC.String(name)
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || (r >= 0x80 && r < 0xa0) || r == utf8.RuneError ||
+			strings.ContainsRune(`/\<>:"|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.TrimRight(strings.TrimLeft(name, " "), ". ")
+	if name == "" {
+		return "_"
+	}
+	if isReservedName(name) {
+		name = "_" + name
+	}
+	ext := filepath.Ext(name)
+	if len(ext) > 16 {
+		ext = ""
+	}
+	return withSuffix(name[:len(name)-len(ext)], "", ext)
+}
+
+// withSuffix returns base+suffix+ext, shortening base to fit into MaxNameLen.
+func withSuffix(base, suffix, ext string) string {
+	n := MaxNameLen - len(suffix) - len(ext)
+	if len(base) > n {
+		for n > 0 && !utf8.RuneStart(base[n]) {
+			n--
+		}
+		if base = strings.TrimRight(base[:n], ". "); base == "" {
+			base = "_"
+		}
+	}
+	return base + suffix + ext
+}
+
+// foldName returns the key for case-insensitive comparison of names.
+func foldName(name string) string { return strings.ToLower(name) }
+
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true, "CONIN$": true, "CONOUT$": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// isReservedName reports whether name is reserved on Windows,
+// even with an extension (such as "nul.txt").
+func isReservedName(name string) bool {
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		name = name[:i]
+	}
+	return reservedNames[strings.ToUpper(strings.TrimRight(name, " "))]
+}
+
+// A PhotoSidecar is the content of the JSON file written next to a
+// downloaded photo. Photo.Filename is the original name, LocalName is
+// the (sanitised) one it is stored under.
+type PhotoSidecar struct {
+	Photo
+	LocalName string
+}
+
+// An AlbumSidecar is the content of an album's AlbumSidecarName file.
+// LocalName is the name of the album's directory.
+type AlbumSidecar struct {
+	Album
+	LocalName string
+}
+
+// WritePhotoSidecar writes the PhotoSidecar of the photo downloaded to
+// path into path+".json".
+func WritePhotoSidecar(path string, p Photo) error {
+	return writeJSON(path+".json", PhotoSidecar{Photo: p, LocalName: filepath.Base(path)})
+}
+
+// ReadPhotoSidecar reads the PhotoSidecar of the photo at path.
+func ReadPhotoSidecar(path string) (PhotoSidecar, error) {
+	var sc PhotoSidecar
+	err := readJSON(path+".json", &sc)
+	return sc, err
+}
+
+// WriteAlbumSidecar writes the AlbumSidecarName file of the album into dir.
+func WriteAlbumSidecar(dir string, a Album) error {
+	return writeJSON(filepath.Join(dir, AlbumSidecarName), AlbumSidecar{Album: a, LocalName: filepath.Base(dir)})
+}
+
+// ReadAlbumSidecar reads the AlbumSidecarName file in dir.
+func ReadAlbumSidecar(dir string) (AlbumSidecar, error) {
+	var sc AlbumSidecar
+	err := readJSON(filepath.Join(dir, AlbumSidecarName), &sc)
+	return sc, err
+}
+
+func writeJSON(path string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0640)
+}
+
+func readJSON(path string, v interface{}) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
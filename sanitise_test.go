@@ -0,0 +1,93 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package picago
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSanitiseName(t *testing.T) {
+	for in, want := range map[string]string{
+		"IMG_0001.JPG":        "IMG_0001.JPG",
+		"a/b\\c.jpg":          "a_b_c.jpg",
+		"tab\there\x00.png":   "tab_here_.png",
+		`what?<>:"|*.jpg`:     "what_______.jpg",
+		"con":                 "_con",
+		"Nul.txt":             "_Nul.txt",
+		"COM1 .jpg":           "_COM1 .jpg",
+		"console.jpg":         "console.jpg",
+		"trailing. . ":        "trailing",
+		"  leading":           "leading",
+		"":                    "_",
+		".":                   "_",
+		"..":                  "_",
+		"Cafe\u0301.jpg":      "Caf\u00e9.jpg",
+		"ok \xff bytes.jpg":   "ok _ bytes.jpg",
+		"\u0085next-line.gif": "_next-line.gif",
+	} {
+		if got := SanitiseName(in); got != want {
+			t.Errorf("%q: got %q, wanted %q", in, got, want)
+		}
+	}
+
+	long := strings.Repeat("á", 300) + ".jpeg"
+	got := SanitiseName(long)
+	if len(got) > MaxNameLen || !strings.HasSuffix(got, ".jpeg") || !utf8.ValidString(got) {
+		t.Errorf("long name: got %q (%d bytes)", got, len(got))
+	}
+
+	names := PhotoFileNames([]Photo{
+		{ID: "1", Filename: long},
+		{ID: "2", Filename: strings.ToUpper(long)},
+	})
+	if len(names["2"]) > MaxNameLen || !strings.Contains(names["2"], "_2.") {
+		t.Errorf("long collision: got %q (%d bytes)", names["2"], len(names["2"]))
+	}
+}
+
+func TestSidecarMapping(t *testing.T) {
+	dir, err := ioutil.TempDir("", "picago-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := Photo{ID: "1", Filename: "2014/07/aux.jpg"}
+	fn := filepath.Join(dir, PhotoFileNames([]Photo{p})[p.ID])
+	if want := filepath.Join(dir, "2014_07_aux.jpg"); fn != want {
+		t.Errorf("got %q, wanted %q", fn, want)
+	}
+	if err = WritePhotoSidecar(fn, p); err != nil {
+		t.Fatal(err)
+	}
+	sc, err := ReadPhotoSidecar(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.Filename != p.Filename || sc.LocalName != filepath.Base(fn) {
+		t.Errorf("got %q -> %q, wanted %q -> %q", sc.Filename, sc.LocalName, p.Filename, filepath.Base(fn))
+	}
+
+	a := Album{ID: "42", Title: "A/B"}
+	albumDir := filepath.Join(dir, AlbumDirName(a))
+	if err = os.Mkdir(albumDir, 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err = WriteAlbumSidecar(albumDir, a); err != nil {
+		t.Fatal(err)
+	}
+	asc, err := ReadAlbumSidecar(albumDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if asc.Title != a.Title || asc.LocalName != "A_B [42]" {
+		t.Errorf("got %q -> %q", asc.Title, asc.LocalName)
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package picago
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+// A FeedDecoder reads the entries of a feed one by one, without holding
+// the whole feed in memory.
+//
+// The feed-level metadata is collected into Feed as it is seen;
+// as Picasa sends it before the entries, it is complete when the first
+// entry is returned by Next.
+type FeedDecoder struct {
+	feed Atom
+	next func(*Atom) (*Entry, error)
+}
+
+// NewFeedDecoder returns a FeedDecoder reading Atom XML from r.
+// r may contain an Atom feed or a single entry.
+func NewFeedDecoder(r io.Reader) *FeedDecoder {
+	xd := &xmlFeedDecoder{d: xml.NewDecoder(r)}
+	return &FeedDecoder{next: xd.next}
+}
+
+// Feed returns the feed-level metadata read so far.
+// Its Entries are always empty.
+func (fd *FeedDecoder) Feed() *Atom {
+	return &fd.feed
+}
+
+// Next returns the next entry of the feed, or io.EOF at the end.
+func (fd *FeedDecoder) Next() (*Entry, error) {
+	return fd.next(&fd.feed)
+}
+
+type xmlFeedDecoder struct {
+	d      *xml.Decoder
+	inFeed bool
+	done   bool
+}
+
+func (fd *xmlFeedDecoder) next(feed *Atom) (*Entry, error) {
+	if fd.done {
+		return nil, io.EOF
+	}
+	for {
+		tok, err := fd.d.Token()
+		if err != nil {
+			if err == io.EOF && !fd.inFeed {
+				err = io.ErrUnexpectedEOF
+			}
+			fd.done = true
+			return nil, err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			if !fd.inFeed {
+				switch tok.Name.Local {
+				case "feed":
+					fd.inFeed = true
+					continue
+				case "entry":
+					fd.done = true
+					return fd.decodeEntry(tok)
+				}
+				fd.done = true
+				return nil, fmt.Errorf("unexpected root element %q", tok.Name.Local)
+			}
+			if tok.Name.Local == "entry" {
+				return fd.decodeEntry(tok)
+			}
+			if err := fd.decodeMeta(feed, tok); err != nil {
+				fd.done = true
+				return nil, err
+			}
+		case xml.EndElement:
+			// Children are consumed whole, so this is the end of the feed.
+			fd.done = true
+			return nil, io.EOF
+		}
+	}
+}
+
+func (fd *xmlFeedDecoder) decodeEntry(start xml.StartElement) (*Entry, error) {
+	e := new(Entry)
+	if err := fd.d.DecodeElement(e, &start); err != nil {
+		fd.done = true
+		return nil, err
+	}
+	return e, nil
+}
+
+// decodeMeta decodes a child of the feed element into the matching
+// field of Atom, mirroring its xml tags.
+func (fd *xmlFeedDecoder) decodeMeta(f *Atom, start xml.StartElement) error {
+	var v interface{}
+	switch start.Name.Local {
+	case "id":
+		v = &f.ID
+	case "name":
+		v = &f.Name
+	case "updated":
+		v = &f.Updated
+	case "title":
+		v = &f.Title
+	case "subtitle":
+		v = &f.Subtitle
+	case "icon":
+		v = &f.Icon
+	case "thumbnail":
+		if start.Name.Space == gphotoNS {
+			v = &f.Thumbnail
+		}
+	case "author":
+		v = &f.Author
+	case "numphotos":
+		v = &f.NumPhotos
+	case "startIndex":
+		v = &f.StartIndex
+	case "totalResults":
+		v = &f.TotalResults
+	case "itemsPerPage":
+		v = &f.ItemsPerPage
+	case "category":
+		var c Category
+		if err := fd.d.DecodeElement(&c, &start); err != nil {
+			return err
+		}
+		f.Categories = append(f.Categories, c)
+		return nil
+	}
+	if v == nil {
+		return fd.d.Skip()
+	}
+	return fd.d.DecodeElement(v, &start)
+}
@@ -6,13 +6,12 @@ package picago
 
 import (
 	"bytes"
+	"encoding/xml"
 	"fmt"
-	"html"
-	"io/ioutil"
+	"io"
 	"mime/multipart"
 	"net/http"
 	"net/textproto"
-	"strconv"
 	"strings"
 )
 
@@ -26,6 +25,15 @@ summary is the caption of the photo.
 MIME is the Content-Type, only support "image/bmp", "image/gif", "image/jpeg", and "image/png"
 */
 func UploadPhoto(client *http.Client, userID, albumID, fileName, summary, MIME string, photoRaw []byte) (*Photo, error) {
+	return (&Client{Client: client}).Upload(userID, albumID, Photo{Filename: fileName, Description: summary}, MIME, bytes.NewReader(photoRaw))
+}
+
+// Upload uploads the photo (or video) read from r into the album, with
+// the metadata of p: Filename, Description, Keywords, Location, Point (or
+// Latitude and Longitude), Timestamp and Checksum. The other fields of p
+// are ignored.
+// If userID or albumID is empty, "default" is used.
+func (c *Client) Upload(userID, albumID string, p Photo, MIME string, r io.Reader) (*Photo, error) {
 	if userID == "" {
 		userID = "default"
 	}
@@ -36,47 +44,113 @@ func UploadPhoto(client *http.Client, userID, albumID, fileName, summary, MIME s
 	url = strings.Replace(url, "{albumID}", albumID, 1)
 	url = url[0:strings.LastIndex(url, "?")]
 
-	buf := bytes.NewBuffer(nil)
-	w := multipart.NewWriter(buf)
-	sw, _ := w.CreatePart(textproto.MIMEHeader{
+	meta := Photo{
+		Filename: p.Filename, Description: p.Description, Keywords: p.Keywords,
+		Location: p.Location, Point: p.Point, Latitude: p.Latitude, Longitude: p.Longitude,
+		Timestamp: p.Timestamp, Checksum: p.Checksum,
+	}
+	// The body is streamed, so r is not held in memory.
+	pr, pw := io.Pipe()
+	w := multipart.NewWriter(pw)
+	errc := make(chan error, 1)
+	go func() {
+		err := writeUpload(w, meta, MIME, r)
+		pw.CloseWithError(err)
+		errc <- err
+	}()
+	entry, err := c.postEntry(url, "multipart/related; boundary="+w.Boundary(), pr)
+	pr.Close()
+	if werr := <-errc; werr != nil && werr != io.ErrClosedPipe {
+		return nil, werr
+	}
+	if err != nil {
+		return nil, err
+	}
+	photo, err := entry.photo()
+	if err != nil {
+		return nil, err
+	}
+	return &photo, nil
+}
+
+// writeUpload writes the multipart body of an upload: the metadata, then
+// the content read from r.
+func writeUpload(w *multipart.Writer, meta Photo, MIME string, r io.Reader) error {
+	sw, err := w.CreatePart(textproto.MIMEHeader{
 		"Content-Type": []string{"application/atom+xml"},
 	})
-	fmt.Fprintf(sw,
-		"<entry xmlns='http://www.w3.org/2005/Atom'><title>%s</title><summary>%s</summary><category scheme='http://schemas.google.com/g/2005#kind' term='http://schemas.google.com/photos/2007#photo'/></entry>\r\n",
-		html.EscapeString(fileName),
-		html.EscapeString(summary),
-	)
-	sw, _ = w.CreatePart(textproto.MIMEHeader{
+	if err != nil {
+		return err
+	}
+	if err = xml.NewEncoder(sw).Encode(meta.Entry()); err != nil {
+		return err
+	}
+	if _, err = io.WriteString(sw, "\r\n"); err != nil {
+		return err
+	}
+	if sw, err = w.CreatePart(textproto.MIMEHeader{
 		"Content-Type": []string{MIME},
-	})
-	sw.Write(photoRaw)
-	w.Close()
+	}); err != nil {
+		return err
+	}
+	if _, err = io.Copy(sw, r); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// CreateAlbum creates an album with the Title, Description, Location,
+// Access (or if it is empty, Rights), Timestamp, Point, CommentingEnabled,
+// AllowDownloads and AllowPrints of a, and returns the created album.
+// If userID is empty, "default" is used.
+func (c *Client) CreateAlbum(userID string, a Album) (Album, error) {
+	if userID == "" {
+		userID = "default"
+	}
+	url := strings.Replace(albumURL, "{userID}", userID, 1)
+	url = url[0:strings.LastIndex(url, "?")]
+
+	meta := Album{
+		Title: a.Title, Description: a.Description, Location: a.Location,
+		Access: a.Access, Timestamp: a.Timestamp, Point: a.Point,
+		CommentingEnabled: a.CommentingEnabled, AllowDownloads: a.AllowDownloads, AllowPrints: a.AllowPrints,
+	}
+	if meta.Access == "" {
+		meta.Access = a.Rights
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := xml.NewEncoder(buf).Encode(meta.Entry()); err != nil {
+		return Album{}, err
+	}
+	entry, err := c.postEntry(url, "application/atom+xml", buf)
+	if err != nil {
+		return Album{}, fmt.Errorf("CreateAlbum(%s) %v", url, err)
+	}
+	return entry.album(), nil
+}
 
-	req, err := http.NewRequest(http.MethodPost, url, buf)
+// postEntry posts the body to url, and returns the entry in the response.
+// The error of a response with an error status is a *StatusError.
+func (c *Client) postEntry(url, contentType string, body io.Reader) (*Entry, error) {
+	req, err := http.NewRequest(http.MethodPost, url, body)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Content-Type", "multipart/related; boundary="+w.Boundary())
-	req.Header.Set("Content-Length", strconv.Itoa(buf.Len()))
+	req.Header.Set("Content-Type", contentType)
 	req.Header.Set("MIME-version", "1.0")
 
-	resp, err := client.Do(req)
+	resp, err := c.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode >= http.StatusBadRequest {
-		buf, _ := ioutil.ReadAll(resp.Body)
-		return nil, fmt.Errorf("UploadPhoto(%s) got %s (%s)", url, resp.Status, buf)
+		return nil, newStatusError(url, resp)
 	}
 
 	var entry Entry
 	if err := entry.DecodeReader(resp.Body); err != nil {
 		return nil, err
 	}
-	photo, err := entry.photo()
-	if err != nil {
-		return nil, err
-	}
-	return &photo, nil
+	return &entry, nil
 }
@@ -0,0 +1,369 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package picago
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// uploadTypes are the Content-Types of the uploadable file extensions.
+var uploadTypes = map[string]string{
+	".bmp":  "image/bmp",
+	".gif":  "image/gif",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".3gp":  "video/3gpp",
+	".avi":  "video/avi",
+	".m4v":  "video/mp4",
+	".mov":  "video/quicktime",
+	".mp4":  "video/mp4",
+	".mpg":  "video/mpeg",
+	".mpeg": "video/mpeg",
+	".wmv":  "video/x-ms-wmv",
+}
+
+// UploadType returns the Content-Type of the file at path, by its
+// extension, or the empty string if it cannot be uploaded.
+func UploadType(path string) string {
+	return uploadTypes[strings.ToLower(filepath.Ext(path))]
+}
+
+// An UploadJob is a local file to be uploaded by an Uploader.
+type UploadJob struct {
+	Path string
+
+	// Photo is the metadata to upload (see Client.Upload). If it comes
+	// from a sidecar, its ID is of the photo the file was downloaded from.
+	Photo Photo
+}
+
+// DirUploadJobs returns the UploadJobs of the uploadable files of dir
+// (not recursing), in the order of their names. The metadata is read
+// from the photos' sidecars (see ReadPhotoSidecar), if they exist.
+func DirUploadJobs(dir string) ([]UploadJob, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var jobs []UploadJob
+	for _, fi := range fis {
+		if fi.Mode()&os.ModeSymlink != 0 {
+			// Follow the links of a deduplicated mirror.
+			if fi, err = os.Stat(filepath.Join(dir, fi.Name())); err != nil {
+				return nil, err
+			}
+		}
+		if !fi.Mode().IsRegular() || UploadType(fi.Name()) == "" {
+			continue
+		}
+		job := UploadJob{Path: filepath.Join(dir, fi.Name()), Photo: Photo{Filename: fi.Name()}}
+		sc, err := ReadPhotoSidecar(job.Path)
+		if err == nil {
+			job.Photo = sc.Photo
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// BlobUploadJobs returns the UploadJobs of the photos of dir kept in the
+// DedupeBlobs store in blobDir, as listed in the BlobIndexName file of dir,
+// in the order of their names. Their Paths are the blobs', the metadata
+// is read from the sidecars in dir.
+func BlobUploadJobs(dir, blobDir string) ([]UploadJob, error) {
+	idx, err := ReadBlobIndex(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(idx))
+	for name := range idx {
+		if UploadType(name) != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	jobs := make([]UploadJob, 0, len(names))
+	for _, name := range names {
+		job := UploadJob{Path: filepath.Join(blobDir, filepath.FromSlash(idx[name].Blob)), Photo: Photo{Filename: name}}
+		sc, err := ReadPhotoSidecar(filepath.Join(dir, name))
+		if err == nil {
+			job.Photo = sc.Photo
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// An UploadResult is the outcome of an UploadJob.
+type UploadResult struct {
+	UploadJob
+
+	// Uploaded is the new photo, or with Skipped, the one already there.
+	Uploaded *Photo
+
+	// Skipped is true if the photo was already in the album.
+	Skipped bool
+
+	// Attempts is the number of tries made.
+	Attempts int
+
+	// Err is the error of the last attempt, nil on success.
+	Err error
+}
+
+// An Uploader uploads photos concurrently.
+type Uploader struct {
+	Client *Client
+
+	// UserID is the owner of the albums, "default" if empty.
+	UserID string
+
+	// Concurrency is the number of parallel uploads, 4 by default.
+	Concurrency int
+
+	// Retries is the number of retries after an upload failed for a
+	// network or server error (5xx, 429). The album is checked for the
+	// photo before each retry, as the failed attempt may have stored it.
+	Retries int
+
+	// RetryDelay is the wait before the first retry, doubled before each
+	// subsequent one. One second by default.
+	RetryDelay time.Duration
+
+	// BlobDir is the store of a DedupeBlobs mirror (see Deduper), to
+	// upload the photos listed in the blob indexes of the directories.
+	BlobDir string
+}
+
+// EnsureAlbum returns the album of the user titled as a, creating it
+// (see Client.CreateAlbum) if there is none. It reports whether it has
+// created the album.
+func (u *Uploader) EnsureAlbum(a Album) (Album, bool, error) {
+	old, ok, err := u.findAlbum(a.Title)
+	if ok || err != nil {
+		return old, false, err
+	}
+	a, err = u.Client.CreateAlbum(u.UserID, a)
+	return a, err == nil, err
+}
+
+// findAlbum returns the album of the user with the given title.
+func (u *Uploader) findAlbum(title string) (Album, bool, error) {
+	albums, err := u.Client.GetAlbums(u.UserID)
+	if err != nil {
+		return Album{}, false, err
+	}
+	for _, a := range albums {
+		if a.Title == title {
+			return a, true, nil
+		}
+	}
+	return Album{}, false, nil
+}
+
+// UploadDir uploads the files of dir (see DirUploadJobs) into the album
+// titled as the album sidecar of dir says (see ReadAlbumSidecar), or if
+// there is no sidecar, as dir is named. The album is created if needed.
+func (u *Uploader) UploadDir(ctx context.Context, dir string) (Album, []UploadResult, error) {
+	return u.uploadDir(ctx, dir, func(a Album) (Album, error) {
+		a, _, err := u.EnsureAlbum(a)
+		return a, err
+	})
+}
+
+// uploadDir uploads the files of dir into the album returned by ensure
+// for the album of dir (see Uploader.dirAlbum).
+func (u *Uploader) uploadDir(ctx context.Context, dir string, ensure func(Album) (Album, error)) (Album, []UploadResult, error) {
+	a, jobs, err := u.dirAlbum(dir)
+	if err != nil {
+		return Album{}, nil, err
+	}
+	if a, err = ensure(a); err != nil {
+		return Album{}, nil, err
+	}
+	results, err := u.Upload(ctx, a.ID, jobs)
+	return a, results, err
+}
+
+// dirAlbum returns the album to upload dir into, and the UploadJobs of dir:
+// its files, and the photos of its blob index in BlobDir.
+func (u *Uploader) dirAlbum(dir string) (Album, []UploadJob, error) {
+	a := Album{Title: filepath.Base(dir), Access: "private", AllowDownloads: true, AllowPrints: true}
+	if sc, err := ReadAlbumSidecar(dir); err == nil {
+		a = sc.Album
+	} else if !os.IsNotExist(err) {
+		return Album{}, nil, err
+	}
+	jobs, err := DirUploadJobs(dir)
+	if err != nil {
+		return a, nil, err
+	}
+	if _, err = os.Stat(filepath.Join(dir, BlobIndexName)); err == nil {
+		if u.BlobDir == "" {
+			return a, nil, fmt.Errorf("the photos of %s are in a blob store, but BlobDir is not set", dir)
+		}
+		blobJobs, err := BlobUploadJobs(dir, u.BlobDir)
+		if err != nil {
+			return a, nil, err
+		}
+		jobs = append(jobs, blobJobs...)
+	}
+	return a, jobs, nil
+}
+
+// Upload uploads the files into the album, skipping those already there:
+// the photos with the same Filename, and the same checksum (as set by
+// Upload) or size. Results are returned in the order of completion.
+func (u *Uploader) Upload(ctx context.Context, albumID string, jobs []UploadJob) ([]UploadResult, error) {
+	present, err := u.present(albumID)
+	if err != nil {
+		return nil, err
+	}
+
+	n := u.Concurrency
+	if n <= 0 {
+		n = 4
+	}
+	ch := make(chan UploadJob)
+	go func() {
+		defer close(ch)
+		for _, job := range jobs {
+			select {
+			case ch <- job:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	var (
+		mu      sync.Mutex
+		results = make([]UploadResult, 0, len(jobs))
+		wg      sync.WaitGroup
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range ch {
+				res := u.upload(ctx, albumID, job, present[filenameOf(job)])
+				mu.Lock()
+				results = append(results, res)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// present returns the photos of the album by their Filenames.
+func (u *Uploader) present(albumID string) (map[string][]Photo, error) {
+	present := make(map[string][]Photo)
+	err := u.Client.WalkPhotos(u.UserID, albumID, func(p Photo) error {
+		present[p.Filename] = append(present[p.Filename], p)
+		return nil
+	})
+	return present, err
+}
+
+// findPresent returns the photo of present with the given checksum or size.
+func findPresent(present []Photo, checksum string, size int64) *Photo {
+	for _, p := range present {
+		if p.Checksum == checksum || p.Size == size {
+			return &p
+		}
+	}
+	return nil
+}
+
+// filenameOf returns the name the job's file is uploaded with.
+func filenameOf(job UploadJob) string {
+	if job.Photo.Filename != "" {
+		return job.Photo.Filename
+	}
+	return filepath.Base(job.Path)
+}
+
+func (u *Uploader) upload(ctx context.Context, albumID string, job UploadJob, present []Photo) UploadResult {
+	res := UploadResult{UploadJob: job}
+	p := job.Photo
+	p.Filename = filenameOf(job)
+	var size int64
+	if p.Checksum, size, res.Err = fileChecksum(job.Path); res.Err != nil {
+		return res
+	}
+	if res.Uploaded = findPresent(present, p.Checksum, size); res.Uploaded != nil {
+		res.Skipped = true
+		return res
+	}
+
+	delay := u.RetryDelay
+	if delay <= 0 {
+		delay = time.Second
+	}
+	for {
+		if res.Err = ctx.Err(); res.Err != nil {
+			return res
+		}
+		res.Attempts++
+		if res.Attempts > 1 {
+			var present map[string][]Photo
+			if present, res.Err = u.present(albumID); res.Err == nil {
+				res.Uploaded = findPresent(present[p.Filename], p.Checksum, size)
+			}
+		}
+		if res.Err == nil && res.Uploaded == nil {
+			res.Uploaded, res.Err = u.uploadFile(albumID, p, job.Path)
+		}
+		if res.Err == nil || res.Attempts > u.Retries || !temporary(res.Err) {
+			return res
+		}
+		select {
+		case <-time.After(delay):
+			delay *= 2
+		case <-ctx.Done():
+			return res
+		}
+	}
+}
+
+// uploadFile uploads the file at path into the album, with the metadata of p.
+func (u *Uploader) uploadFile(albumID string, p Photo, path string) (*Photo, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	return u.Client.Upload(u.UserID, albumID, p, UploadType(path), fh)
+}
+
+// fileChecksum returns the checksum of the file's content, as set by
+// Uploader, and its size.
+func fileChecksum(path string) (string, int64, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer fh.Close()
+	hsh := sha1.New()
+	n, err := io.Copy(hsh, fh)
+	return hex.EncodeToString(hsh.Sum(nil)), n, err
+}
@@ -0,0 +1,343 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package picago
+
+import (
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// uploadServer is a fakePicasa accepting new albums and photos.
+type uploadServer struct {
+	*fakePicasa
+	nextID int
+
+	// failures is the number of photo uploads answered with status,
+	// after storing the photo for a server error.
+	failures, status int
+}
+
+func (u *uploadServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		u.fakePicasa.ServeHTTP(w, r)
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.nextID++
+	id := strconv.Itoa(100 + u.nextID)
+	var e Entry
+	if i := strings.Index(r.URL.Path, "/albumid/"); i >= 0 {
+		_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		part, err := mr.NextPart()
+		if err == nil {
+			err = e.DecodeReader(part)
+		}
+		if err == nil {
+			part, err = mr.NextPart()
+		}
+		var b []byte
+		if err == nil {
+			b, err = ioutil.ReadAll(part)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		p, err := e.photo()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if u.failures > 0 && u.status < http.StatusInternalServerError {
+			u.failures--
+			http.Error(w, "rejected", u.status)
+			return
+		}
+		albumID := r.URL.Path[i+len("/albumid/"):]
+		p.ID, p.AlbumID, p.Size = id, albumID, int64(len(b))
+		u.photos[albumID] = append(u.photos[albumID], p)
+		if u.failures > 0 {
+			u.failures--
+			http.Error(w, "lost", u.status)
+			return
+		}
+		e = *p.Entry()
+	} else {
+		if err := e.DecodeReader(r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		a := e.album()
+		a.ID = id
+		u.albums = append(u.albums, a)
+		e = *a.Entry()
+	}
+	w.WriteHeader(http.StatusCreated)
+	xml.NewEncoder(w).Encode(e)
+}
+
+func TestUploadDir(t *testing.T) {
+	fake := &uploadServer{fakePicasa: &fakePicasa{photos: make(map[string][]Photo), content: make(map[string]string)}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	root, err := ioutil.TempDir("", "picago-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	dir := filepath.Join(root, "Holiday")
+	if err = os.Mkdir(dir, 0750); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"a.jpg": "aaa", "b.PNG": "bb", "notes.txt": "-"} {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = WritePhotoSidecar(filepath.Join(dir, "a.jpg"), Photo{ID: "1", Filename: "a.jpg", Description: "sea", Keywords: []string{"kids", "beach"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	u := Uploader{Client: &Client{Client: &http.Client{Transport: rewriteTransport{host: srv.Listener.Addr().String()}}}}
+	a, results, err := u.UploadDir(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Title != "Holiday" || len(fake.albums) != 1 {
+		t.Errorf("got album %+v, albums %+v", a, fake.albums)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %d results, wanted 2", len(results))
+	}
+	for _, res := range results {
+		if res.Err != nil || res.Skipped || res.Uploaded == nil {
+			t.Errorf("%s: %+v", res.Path, res)
+		}
+	}
+	photos := fake.photos[a.ID]
+	if len(photos) != 2 {
+		t.Fatalf("got %+v", photos)
+	}
+	for _, p := range photos {
+		if p.Filename == "a.jpg" && (p.Description != "sea" || strings.Join(p.Keywords, ",") != "kids,beach" || p.Checksum == "") {
+			t.Errorf("metadata lost: %+v", p)
+		}
+	}
+
+	// Again: the album is reused, the photos are skipped.
+	if a, results, err = u.UploadDir(context.Background(), dir); err != nil {
+		t.Fatal(err)
+	}
+	for _, res := range results {
+		if res.Err != nil || !res.Skipped {
+			t.Errorf("%s: %+v", res.Path, res)
+		}
+	}
+	if len(fake.albums) != 1 || len(fake.photos[a.ID]) != 2 {
+		t.Errorf("got albums %+v, photos %+v", fake.albums, fake.photos[a.ID])
+	}
+}
+
+func TestUploaderRetry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "picago-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.jpg")
+	if err = ioutil.WriteFile(path, []byte("aaa"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		status       int
+		wantAttempts int
+		wantErr      bool
+		wantPhotos   int
+	}{
+		// The photo is stored, but the answer is lost: it is not uploaded again.
+		{status: http.StatusServiceUnavailable, wantAttempts: 2, wantPhotos: 1},
+		// A bad request is not retried.
+		{status: http.StatusBadRequest, wantAttempts: 1, wantErr: true},
+	} {
+		fake := &uploadServer{
+			fakePicasa: &fakePicasa{photos: make(map[string][]Photo), content: make(map[string]string)},
+			failures:   1, status: tc.status,
+		}
+		srv := httptest.NewServer(fake)
+		u := Uploader{
+			Client:     &Client{Client: &http.Client{Transport: rewriteTransport{host: srv.Listener.Addr().String()}}},
+			Retries:    2,
+			RetryDelay: time.Millisecond,
+		}
+		results, err := u.Upload(context.Background(), "1", []UploadJob{{Path: path}})
+		srv.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := results[0]
+		if res.Attempts != tc.wantAttempts || (res.Err != nil) != tc.wantErr || (res.Uploaded == nil) != tc.wantErr {
+			t.Errorf("%d: got %+v", tc.status, res)
+		}
+		if _, ok := res.Err.(*StatusError); tc.wantErr && !ok {
+			t.Errorf("%d: got %T, wanted a *StatusError", tc.status, res.Err)
+		}
+		if n := len(fake.photos["1"]); n != tc.wantPhotos {
+			t.Errorf("%d: got %d photos", tc.status, n)
+		}
+	}
+}
+
+func TestRestore(t *testing.T) {
+	src := &fakePicasa{photos: make(map[string][]Photo), content: make(map[string]string)}
+	src.albums = []Album{
+		{ID: "1", Title: "Summer", Description: "at the sea", Location: "Balaton", Access: "public", ETag: "a1"},
+		{ID: "2", Title: "Summer", ETag: "a2"},
+	}
+	p := src.photo("1", "11", "a.jpg", "p1", "aaa")
+	p.Description, p.Keywords, p.Point = "sunset", []string{"kids"}, &GeoPoint{Latitude: 46.9, Longitude: 17.9}
+	src.photos["1"] = []Photo{p, src.photo("1", "12", "b.jpg", "p1", "bbb")}
+	// The same title and file name and size, but another album and photo.
+	src.photos["2"] = []Photo{src.photo("2", "21", "a.jpg", "p1", "ccc")}
+	srcSrv := httptest.NewServer(src)
+	defer srcSrv.Close()
+
+	dir, err := ioutil.TempDir("", "picago-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	m := Mirror{
+		Client: &Client{Client: &http.Client{Transport: rewriteTransport{host: srcSrv.Listener.Addr().String()}}},
+		Dir:    dir,
+	}
+	if _, err = m.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := &uploadServer{fakePicasa: &fakePicasa{photos: make(map[string][]Photo), content: make(map[string]string)}}
+	dstSrv := httptest.NewServer(dst)
+	defer dstSrv.Close()
+	u := Uploader{Client: &Client{Client: &http.Client{Transport: rewriteTransport{host: dstSrv.Listener.Addr().String()}}}}
+	idMap, results, err := u.Restore(context.Background(), dir, RestoreMap{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Err != nil || results[1].Err != nil {
+		t.Fatalf("got %+v", results)
+	}
+	if len(dst.albums) != 2 {
+		t.Fatalf("got albums %+v", dst.albums)
+	}
+	a := dst.albums[0]
+	if a.ID != idMap.Albums["1"] {
+		a = dst.albums[1]
+	}
+	if a.Title != "Summer" || a.Description != "at the sea" || a.Location != "Balaton" || a.Access != "public" {
+		t.Errorf("got album %+v", a)
+	}
+	if idMap.Albums["1"] != a.ID || idMap.Albums["2"] == a.ID || len(idMap.Photos) != 3 {
+		t.Errorf("got map %+v", idMap)
+	}
+	if n := len(dst.photos[idMap.Albums["2"]]); n != 1 {
+		t.Errorf("got %d photos in the second album, wanted 1", n)
+	}
+
+	// Again, with the map: the albums and photos are reused.
+	if idMap, results, err = u.Restore(context.Background(), dir, idMap); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst.albums) != 2 || len(dst.photos[a.ID]) != 2 {
+		t.Errorf("got albums %+v, photos %+v", dst.albums, dst.photos)
+	}
+	for _, res := range results {
+		for _, up := range res.Uploads {
+			if !up.Skipped {
+				t.Errorf("%s uploaded again", up.Path)
+			}
+		}
+	}
+	for _, p := range dst.photos[a.ID] {
+		if idMap.Photos["11"] == p.ID && (p.Description != "sunset" || len(p.Keywords) != 1 || p.Point == nil || p.Point.Latitude != 46.9) {
+			t.Errorf("metadata lost: %+v", p)
+		}
+	}
+}
+
+func TestRestoreBlobs(t *testing.T) {
+	src := &fakePicasa{photos: make(map[string][]Photo), content: make(map[string]string)}
+	src.albums = []Album{{ID: "1", Title: "Summer", ETag: "a1"}}
+	src.photos["1"] = []Photo{src.photo("1", "11", "a.jpg", "p1", "aaa"), src.photo("1", "12", "b.jpg", "p1", "bbb")}
+	srcSrv := httptest.NewServer(src)
+	defer srcSrv.Close()
+
+	dir, err := ioutil.TempDir("", "picago-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	blobDir := filepath.Join(dir, ".blobs")
+	d, err := NewDeduper(blobDir, DedupeBlobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	m := Mirror{
+		Client: &Client{Client: &http.Client{Transport: rewriteTransport{host: srcSrv.Listener.Addr().String()}}},
+		Dir:    dir,
+		Dedupe: d,
+	}
+	if _, err = m.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := &uploadServer{fakePicasa: &fakePicasa{photos: make(map[string][]Photo), content: make(map[string]string)}}
+	dstSrv := httptest.NewServer(dst)
+	defer dstSrv.Close()
+	u := Uploader{Client: &Client{Client: &http.Client{Transport: rewriteTransport{host: dstSrv.Listener.Addr().String()}}}}
+	_, results, err := u.Restore(context.Background(), dir, RestoreMap{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Err == nil {
+		t.Errorf("restored without BlobDir: %+v", results)
+	}
+
+	u.BlobDir = blobDir
+	idMap, results, err := u.Restore(context.Background(), dir, RestoreMap{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Err != nil || len(idMap.Photos) != 2 {
+		t.Fatalf("got %+v, map %+v", results, idMap)
+	}
+	for _, p := range dst.photos[idMap.Albums["1"]] {
+		if p.Filename != "a.jpg" && p.Filename != "b.jpg" || p.Size != 3 {
+			t.Errorf("got %+v", p)
+		}
+	}
+
+	if _, _, err = u.Restore(context.Background(), blobDir, RestoreMap{}); err == nil {
+		t.Errorf("no error for a directory without albums")
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package picago
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+)
+
+var xmpNamespaces = []xml.Attr{
+	attr("rdf:about", ""),
+	attr("xmlns:dc", "http://purl.org/dc/elements/1.1/"),
+	attr("xmlns:xmp", "http://ns.adobe.com/xap/1.0/"),
+	attr("xmlns:photoshop", "http://ns.adobe.com/photoshop/1.0/"),
+	attr("xmlns:exif", "http://ns.adobe.com/exif/1.0/"),
+	attr("xmlns:tiff", "http://ns.adobe.com/tiff/1.0/"),
+	attr("xmlns:aux", "http://ns.adobe.com/exif/1.0/aux/"),
+	attr("xmlns:Iptc4xmpCore", "http://iptc.org/std/Iptc4xmpCore/1.0/xmlns/"),
+}
+
+// XMPSidecarPath returns the path of the XMP sidecar of the file at path,
+// as darktable and digiKam look for it.
+func XMPSidecarPath(path string) string { return path + ".xmp" }
+
+// WriteXMPSidecar writes the XMP sidecar of the photo downloaded to path.
+func WriteXMPSidecar(path string, p Photo) error {
+	fh, err := os.Create(XMPSidecarPath(path))
+	if err != nil {
+		return err
+	}
+	if err = WriteXMP(fh, p); err != nil {
+		fh.Close()
+		return err
+	}
+	return fh.Close()
+}
+
+// WriteXMP writes the metadata of the photo as an XMP packet:
+//
+//	Description         dc:description
+//	Keywords            dc:subject
+//	Location            Iptc4xmpCore:Location
+//	Point (or Exif's)   exif:GPSLatitude, exif:GPSLongitude
+//	Updated             xmp:ModifyDate
+//	Exif                exif:*, tiff:Make, tiff:Model, tiff:Orientation, aux:Lens
+//
+// xmp:CreateDate and photoshop:DateCreated are the time the photo was
+// taken: Exif's time, or Timestamp, or Published.
+func WriteXMP(w io.Writer, p Photo) error {
+	x := xmlWriter{enc: xml.NewEncoder(w)}
+	x.enc.Indent("", " ")
+	x.token(xml.ProcInst{Target: "xpacket", Inst: []byte(`begin="` + "\ufeff" + `" id="W5M0MpCehiHzreSzNTczkc9d"`)})
+	x.start("x:xmpmeta", attr("xmlns:x", "adobe:ns:meta/"))
+	x.start("rdf:RDF", attr("xmlns:rdf", "http://www.w3.org/1999/02/22-rdf-syntax-ns#"))
+	x.start("rdf:Description", xmpNamespaces...)
+
+	if p.Description != "" {
+		x.start("dc:description")
+		x.start("rdf:Alt")
+		x.start("rdf:li", attr("xml:lang", "x-default"))
+		x.token(xml.CharData(p.Description))
+		x.end("rdf:li")
+		x.end("rdf:Alt")
+		x.end("dc:description")
+	}
+	if len(p.Keywords) != 0 {
+		x.start("dc:subject")
+		x.start("rdf:Bag")
+		for _, k := range p.Keywords {
+			x.text("rdf:li", k)
+		}
+		x.end("rdf:Bag")
+		x.end("dc:subject")
+	}
+	x.text("Iptc4xmpCore:Location", p.Location)
+
+	taken := p.taken()
+	x.time("xmp:CreateDate", taken)
+	x.time("xmp:ModifyDate", p.Updated)
+	x.time("photoshop:DateCreated", taken)
+
+	e := p.Exif
+	x.text("tiff:Make", e.Make)
+	x.text("tiff:Model", e.Model)
+	if e.Orientation != nil {
+		x.number("tiff:Orientation", int64(*e.Orientation))
+	}
+	x.text("aux:Lens", e.Lens)
+	x.text("exif:ImageUniqueID", e.UID)
+	if t, ok := e.Time(); ok {
+		x.time("exif:DateTimeOriginal", t)
+	}
+	x.text("exif:ExposureTime", xmpExposure(e))
+	x.rational("exif:FNumber", e.FStop)
+	x.rational("exif:FocalLength", e.FocalLength)
+	x.rational("exif:SubjectDistance", e.Distance)
+	if e.ISO != nil {
+		x.start("exif:ISOSpeedRatings")
+		x.start("rdf:Seq")
+		x.text("rdf:li", strconv.Itoa(*e.ISO))
+		x.end("rdf:Seq")
+		x.end("exif:ISOSpeedRatings")
+	}
+	if e.Flash != nil {
+		x.start("exif:Flash", attr("rdf:parseType", "Resource"))
+		x.text("exif:Fired", xmpBool(*e.Flash))
+		x.end("exif:Flash")
+	}
+
+	pt := p.Point
+	if pt == nil && e.Latitude != nil && e.Longitude != nil {
+		pt = &GeoPoint{Latitude: *e.Latitude, Longitude: *e.Longitude}
+	}
+	if pt != nil && pt.Valid() {
+		x.text("exif:GPSVersionID", "2.2.0.0")
+		x.text("exif:GPSLatitude", xmpCoord(pt.Latitude, "N", "S"))
+		x.text("exif:GPSLongitude", xmpCoord(pt.Longitude, "E", "W"))
+	}
+	if e.Altitude != nil {
+		ref := "0"
+		alt := *e.Altitude
+		if alt < 0 {
+			ref, alt = "1", -alt
+		}
+		x.text("exif:GPSAltitudeRef", ref)
+		x.rational("exif:GPSAltitude", &alt)
+	}
+
+	x.end("rdf:Description")
+	x.end("rdf:RDF")
+	x.end("x:xmpmeta")
+	x.token(xml.ProcInst{Target: "xpacket", Inst: []byte(`end="w"`)})
+	return x.flush()
+}
+
+// rational writes f as an XMP rational, if it is not nil.
+func (w *xmlWriter) rational(name string, f *float64) {
+	if f != nil {
+		w.text(name, xmpRational(*f))
+	}
+}
+
+// xmpRational returns f as "numerator/denominator", with 1/1000 precision.
+func xmpRational(f float64) string {
+	den := int64(1000)
+	num := int64(math.Round(f * float64(den)))
+	for _, d := range []int64{2, 5} {
+		for den > 1 && num%d == 0 && den%d == 0 {
+			num, den = num/d, den/d
+		}
+	}
+	return strconv.FormatInt(num, 10) + "/" + strconv.FormatInt(den, 10)
+}
+
+// xmpExposure returns the exposure time as an XMP rational: "1/125" for
+// fractions of a second, "5/2" for longer exposures.
+func xmpExposure(e Exif) string {
+	if e.Exposure != nil && *e.Exposure >= 1 {
+		return xmpRational(*e.Exposure)
+	}
+	return e.ExposureString()
+}
+
+// xmpCoord returns the coordinate in the XMP "DDD,MM.mmmmmmk" format.
+func xmpCoord(deg float64, pos, neg string) string {
+	ref := pos
+	if deg < 0 {
+		ref, deg = neg, -deg
+	}
+	d := math.Floor(deg)
+	return fmt.Sprintf("%d,%.6f%s", int(d), (deg-d)*60, ref)
+}
+
+func xmpBool(b bool) string {
+	if b {
+		return "True"
+	}
+	return "False"
+}
@@ -0,0 +1,88 @@
+// Copyright 2017 Tamás Gulácsi. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package picago
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+	"time"
+)
+
+func TestWriteXMP(t *testing.T) {
+	fstop, exposure, focal, alt := 2.8, 1.0/60, 4.5, -10.0
+	iso, orient, flash := 100, 1, true
+	ts := time.Date(2014, 7, 21, 12, 0, 0, 0, time.UTC).UnixNano() / int64(time.Millisecond)
+	p := Photo{
+		Description: "Biking <with> Blake",
+		Keywords:    []string{"bike", "summer"},
+		Location:    "San Bruno Mountain",
+		Point:       &GeoPoint{Latitude: 37.6879, Longitude: -122.4336},
+		Updated:     time.Date(2014, 8, 1, 0, 0, 0, 0, time.UTC),
+		Exif: Exif{
+			Make: "FUJIFILM", Model: "X100", UID: "abc",
+			FStop: &fstop, Exposure: &exposure, FocalLength: &focal, Altitude: &alt,
+			ISO: &iso, Orientation: &orient, Flash: &flash, Timestamp: &ts,
+		},
+	}
+	var buf bytes.Buffer
+	if err := WriteXMP(&buf, p); err != nil {
+		t.Fatal(err)
+	}
+	var x struct {
+		Desc struct {
+			Description string   `xml:"description>Alt>li"`
+			Subject     []string `xml:"subject>Bag>li"`
+			Location    string   `xml:"http://iptc.org/std/Iptc4xmpCore/1.0/xmlns/ Location"`
+			Created     string   `xml:"http://ns.adobe.com/xap/1.0/ CreateDate"`
+			Model       string   `xml:"http://ns.adobe.com/tiff/1.0/ Model"`
+			FNumber     string   `xml:"http://ns.adobe.com/exif/1.0/ FNumber"`
+			Exposure    string   `xml:"http://ns.adobe.com/exif/1.0/ ExposureTime"`
+			ISO         string   `xml:"ISOSpeedRatings>Seq>li"`
+			Fired       string   `xml:"http://ns.adobe.com/exif/1.0/ Flash>Fired"`
+			Lat         string   `xml:"http://ns.adobe.com/exif/1.0/ GPSLatitude"`
+			Lng         string   `xml:"http://ns.adobe.com/exif/1.0/ GPSLongitude"`
+			Alt         string   `xml:"http://ns.adobe.com/exif/1.0/ GPSAltitude"`
+			AltRef      string   `xml:"http://ns.adobe.com/exif/1.0/ GPSAltitudeRef"`
+		} `xml:"http://www.w3.org/1999/02/22-rdf-syntax-ns# RDF>Description"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &x); err != nil {
+		t.Fatal(err)
+	}
+	d := x.Desc
+	for _, tc := range []struct{ name, got, want string }{
+		{"description", d.Description, p.Description},
+		{"location", d.Location, p.Location},
+		{"created", d.Created, "2014-07-21T12:00:00Z"},
+		{"model", d.Model, "X100"},
+		{"fnumber", d.FNumber, "14/5"},
+		{"exposure", d.Exposure, "1/60"},
+		{"iso", d.ISO, "100"},
+		{"flash", d.Fired, "True"},
+		{"lat", d.Lat, "37,41.274000N"},
+		{"lng", d.Lng, "122,26.016000W"},
+		{"alt", d.Alt, "10/1"},
+		{"altref", d.AltRef, "1"},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("%s: got %q, wanted %q", tc.name, tc.got, tc.want)
+		}
+	}
+	if len(d.Subject) != 2 || d.Subject[1] != "summer" {
+		t.Errorf("subject: got %q", d.Subject)
+	}
+}
+
+func TestXMPExposure(t *testing.T) {
+	for exp, want := range map[float64]string{0: "", 1.0 / 60: "1/60", 1: "1/1", 2.5: "5/2", 30: "30/1"} {
+		exp := exp
+		if got := xmpExposure(Exif{Exposure: &exp}); got != want {
+			t.Errorf("%g: got %q, wanted %q", exp, got, want)
+		}
+	}
+	if got := xmpExposure(Exif{}); got != "" {
+		t.Errorf("unknown: got %q", got)
+	}
+}